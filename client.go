@@ -1,11 +1,23 @@
 // Package musicmanager implements a client for managing Google Play
 // Music libraries.
+//
+// The methods of Client that use the locker service (QueryTracks, the
+// playlist listing and mutation methods, UpdateTracks, DeleteTracks,
+// UndeleteTracks, the album, artist and genre listings, Stats,
+// ListDynamicPlaylistEntries, GenerateMix and the lookup methods) are
+// experimental.  The location of Google's locker service and the names
+// of its endpoints are not publicly known, so these methods fail with
+// ErrNoLocker unless Client.Endpoints.Locker is set, and they have only
+// been tested against package musicmanagertest.
 package musicmanager
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/textproto"
+	"sync"
+	"time"
 
 	convert "github.com/lxr/go.google.musicmanager/internal/convert"
 	mmdspb "github.com/lxr/go.google.musicmanager/internal/download_proto/service"
@@ -18,10 +30,73 @@ import (
 // Scope is the OAuth scope used by this API.
 const Scope = "https://www.googleapis.com/auth/musicmanager"
 
-// Client is a Music Manager client.
+// Endpoints holds the URLs of the services a Client talks to.
+type Endpoints struct {
+	// The base URL of the upload service; the individual calls are
+	// made to endpoints under it.
+	UploadService string
+
+	// The URL from which track listings are requested.
+	ExportIDs string
+
+	// The URL from which track download URLs are requested.
+	Export string
+
+	// The URL from which track upload sessions are requested.
+	UploadSession string
+
+	// The base URL of the locker service; the individual calls are
+	// made to endpoints under it, named after the methods of the
+	// service in lowercase (for example, gettracks).  The location of
+	// Google's locker service is not publicly known, so it is empty
+	// in DefaultEndpoints, and the methods that use it fail with
+	// ErrNoLocker until it is set.
+	Locker string
+}
+
+// DefaultEndpoints are the endpoints of Google's Music Manager service.
+var DefaultEndpoints = Endpoints{
+	UploadService: "https://android.clients.google.com/upsj/",
+	ExportIDs:     "https://music.google.com/music/exportids",
+	Export:        "https://music.google.com/music/export",
+	UploadSession: "https://uploadsj.clients.google.com/uploadsj/scottyagent",
+}
+
+// Client is a Music Manager client.  The methods of Client whose names
+// end in Context bind the HTTP requests they make to the given context,
+// so that they can be cancelled or given deadlines; the other methods
+// use context.Background().
 type Client struct {
 	client *http.Client
 	id     string
+
+	// If ReportUploadState is true, ImportTracks declares an upload
+	// session started before importing the tracks, unless it already
+	// is, and reports the progress of the batch when acquiring
+	// upload URLs.  The caller is then responsible for calling
+	// SetUploadState with UploadStopped once it has finished
+	// uploading the tracks.
+	ReportUploadState bool
+
+	// If DetectConflicts is true, the methods that modify items in
+	// the locker, such as UpdateTracks, ask the server to reject a
+	// modification with ErrConflict if the item has been modified
+	// on the server after the LastModifiedTimestamp given for it.
+	DetectConflicts bool
+
+	// UploadConcurrency is the maximum number of tracks Upload
+	// sends to the server at once.  If it is zero, Upload uses
+	// DefaultUploadConcurrency.
+	UploadConcurrency int
+
+	// Endpoints are the URLs of the services the client talks to.
+	// NewClient initializes them to DefaultEndpoints; they can be
+	// changed to point the client at a proxy or a test server.
+	Endpoints Endpoints
+
+	mu        sync.Mutex
+	policy    *ClientPolicy
+	uploading bool // whether the upload session is declared started
 }
 
 // NewClient creates a new Music Manager client with the given device ID
@@ -34,7 +109,11 @@ func NewClient(client *http.Client, deviceID string) (*Client, error) {
 	if deviceID == "" {
 		return nil, fmt.Errorf("device ID is empty")
 	}
-	return &Client{client, deviceID}, nil
+	return &Client{
+		client:    client,
+		id:        deviceID,
+		Endpoints: DefaultEndpoints,
+	}, nil
 }
 
 // BUG(lor): Exporting tracks has been known to fail if the device ID of
@@ -50,7 +129,12 @@ func NewClient(client *http.Client, deviceID string) (*Client, error) {
 // accounts one device can be registered, and how many devices a user
 // can deregister in a year, so be judicious in using this call.
 func (c *Client) Register(name string) error {
-	res, err := c.upAuth(&mmuspb.UpAuthRequest{
+	return c.RegisterContext(context.Background(), name)
+}
+
+// RegisterContext is like Register but takes a context.
+func (c *Client) RegisterContext(ctx context.Context, name string) error {
+	res, err := c.upAuth(ctx, &mmuspb.UpAuthRequest{
 		UploaderId:   c.id,
 		FriendlyName: name,
 	})
@@ -63,13 +147,130 @@ func (c *Client) Register(name string) error {
 	return nil
 }
 
+// State returns the current quota and usage information of the user's
+// Play Music library.  It can be used to check in advance whether
+// importing a batch of tracks would exceed the library's track limit.
+func (c *Client) State() (*ClientState, error) {
+	return c.StateContext(context.Background())
+}
+
+// StateContext is like State but takes a context.
+func (c *Client) StateContext(ctx context.Context) (*ClientState, error) {
+	res, err := c.clientState(ctx, &mmuspb.ClientStateRequest{
+		UploaderId: c.id,
+	})
+	if err != nil {
+		return nil, err
+	}
+	state := new(ClientState)
+	convert.Convert(state, res)
+	return state, nil
+}
+
+// PendingJobs returns the tracks the server is waiting for the client
+// to upload.  These include tracks whose metadata has been imported but
+// whose audio has not yet been received, as well as tracks the server
+// wants re-uploaded.  The ClientId of each job refers to the ClientId
+// of the track originally passed to ImportTracks.
+func (c *Client) PendingJobs() ([]*Job, error) {
+	return c.PendingJobsContext(context.Background())
+}
+
+// PendingJobsContext is like PendingJobs but takes a context.
+func (c *Client) PendingJobsContext(ctx context.Context) ([]*Job, error) {
+	res, err := c.getJobs(ctx, &mmuspb.GetJobsRequest{
+		UploaderId: c.id,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !res.GetTracksSuccess {
+		return nil, fmt.Errorf("musicmanager: server failed to get jobs")
+	}
+	jobs := make([]*Job, 0, len(res.TracksToUpload))
+	convert.Convert(&jobs, res.TracksToUpload)
+	return jobs, nil
+}
+
+// SetUploadState declares the client's upload session started, paused
+// or stopped.  The Play Music web interface uses this information to
+// display the progress of uploads from the client.
+func (c *Client) SetUploadState(state UploadState) error {
+	return c.SetUploadStateContext(context.Background(), state)
+}
+
+// SetUploadStateContext is like SetUploadState but takes a context.
+func (c *Client) SetUploadStateContext(ctx context.Context, state UploadState) error {
+	err := c.updateUploadState(ctx, &mmuspb.UpdateUploadStateRequest{
+		UploaderId: c.id,
+		State:      mmuspb.UpdateUploadStateRequest_UploadState(state),
+	})
+	if err == nil {
+		c.mu.Lock()
+		c.uploading = state == UploadStarted
+		c.mu.Unlock()
+	}
+	return err
+}
+
+// DeleteUploadRequests clears the server's list of tracks awaiting
+// upload from the client, as returned by PendingJobs.
+func (c *Client) DeleteUploadRequests() error {
+	return c.DeleteUploadRequestsContext(context.Background())
+}
+
+// DeleteUploadRequestsContext is like DeleteUploadRequests but takes a context.
+func (c *Client) DeleteUploadRequestsContext(ctx context.Context) error {
+	return c.deleteUploadRequested(ctx, &mmuspb.DeleteUploadRequestedRequest{
+		UploaderId: c.id,
+	})
+}
+
+// Policy returns the most recent client policy sent by the server, or
+// nil if the server has not sent one yet.  The policy is updated by
+// every call to Register, State, PendingJobs, SetUploadState,
+// DeleteUploadRequests and ImportTracks whose response carries one.
+// Since the server does not necessarily send a new policy to lift a
+// pause or an abort, Policy reports them lifted once the retry time of
+// the policy has passed.  A pause or an abort without a retry interval
+// stays in force until the server sends a new policy.
+func (c *Client) Policy() *ClientPolicy {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.policy == nil {
+		return nil
+	}
+	p := *c.policy
+	if p.RetryInMinutes > 0 && !time.Now().Before(p.RetryAt()) {
+		p.PauseUploads = false
+		p.PauseDownloads = false
+		p.Abort = false
+	}
+	return &p
+}
+
+// setPolicy records the client policy p received from the server.
+func (c *Client) setPolicy(p *mmudpb.ClientPolicy) {
+	policy := new(ClientPolicy)
+	convert.Convert(policy, p)
+	policy.Received = time.Now()
+	c.mu.Lock()
+	c.policy = policy
+	c.mu.Unlock()
+}
+
 // ExportTrack returns a short-lived download URL for the given track,
 // identified by its server ID.  Downloading the track from this URL
 // requires no authentication.  The Content-Disposition and
 // Content-Length headers of the response contain the name and size of
 // the track respectively.
 func (c *Client) ExportTrack(id string) (string, error) {
-	res, err := c.getDownloadSession(&mmssjs.GetDownloadSessionRequest{
+	return c.ExportTrackContext(context.Background(), id)
+}
+
+// ExportTrackContext is like ExportTrack but takes a context.
+func (c *Client) ExportTrackContext(ctx context.Context, id string) (string, error) {
+	res, err := c.getDownloadSession(ctx, &mmssjs.GetDownloadSessionRequest{
 		XDeviceID: c.id,
 		SongID:    id,
 	})
@@ -86,6 +287,11 @@ func (c *Client) ExportTrack(id string) (string, error) {
 // in which case the PageToken field of the TrackList object should
 // be given to a new ListTracks call.
 func (c *Client) ListTracks(purchasedOnly bool, updatedMin int64, pageToken string) (*TrackList, error) {
+	return c.ListTracksContext(context.Background(), purchasedOnly, updatedMin, pageToken)
+}
+
+// ListTracksContext is like ListTracks but takes a context.
+func (c *Client) ListTracksContext(ctx context.Context, purchasedOnly bool, updatedMin int64, pageToken string) (*TrackList, error) {
 	var exportType mmdspb.GetTracksToExportRequest_TracksToExportType
 	switch purchasedOnly {
 	case true:
@@ -93,7 +299,7 @@ func (c *Client) ListTracks(purchasedOnly bool, updatedMin int64, pageToken stri
 	case false:
 		exportType = mmdspb.GetTracksToExportRequest_ALL
 	}
-	res, err := c.getTracksToExport(&mmdspb.GetTracksToExportRequest{
+	res, err := c.getTracksToExport(ctx, &mmdspb.GetTracksToExportRequest{
 		ClientId:          c.id,
 		ExportType:        exportType,
 		UpdatedMin:        updatedMin,
@@ -125,9 +331,12 @@ func (c *Client) ListTracks(purchasedOnly bool, updatedMin int64, pageToken stri
 }
 
 // ImportTracks returns short-lived upload URLs for the given tracks.
-// MP3 audio data can be PUT or POSTed to these URLs without
-// authentication.  Individual tracks can fail, in which case errs[i]
-// contains the reason why importing tracks[i] failed.
+// Audio data can be PUT or POSTed to these URLs without authentication,
+// with the Content-Type header set to the MIME type of the track's
+// ContentType.  Individual tracks can fail, in which case errs[i]
+// contains the reason why importing tracks[i] failed.  Tracks of
+// content types that cannot be imported are failed without contacting
+// the server.
 //
 // The Title field of a track to be imported cannot be empty.  The
 // server also uses the ClientId field to identify which tracks have
@@ -140,7 +349,52 @@ func (c *Client) ListTracks(purchasedOnly bool, updatedMin int64, pageToken stri
 // A response to a request sent to a URL returned from this function can
 // report failure through means other than the status code.  Use
 // CheckImportResponse to verify that the request succeeded.
+//
+// If the client policy returned by Policy asks the client to pause or
+// abort its uploads, ImportTracks fails all tracks with a *BackoffError
+// without contacting the server.  Calling State fetches a new policy.  The same happens if the server asks the
+// client to back off in response to the import.
+//
+// If c.ReportUploadState is true, ImportTracks declares the upload
+// session started before importing the tracks, unless it already is.
+// If ImportTracks started the session and none of the tracks can be
+// uploaded, it declares the session stopped again.
+//
+// The album art of a track is sent only if the server asks for a sample
+// of the track.  If the server reports a URL for the album art of a
+// track, ImportTracks stores it in the track's AlbumArtUrl field.
 func (c *Client) ImportTracks(tracks []*Track) (urls []string, errs []error) {
+	return c.ImportTracksContext(context.Background(), tracks)
+}
+
+// ImportTracksContext is like ImportTracks but takes a context.
+func (c *Client) ImportTracksContext(ctx context.Context, tracks []*Track) (urls []string, errs []error) {
+	return c.importTracks(ctx, tracks, nil)
+}
+
+// An uploadCount counts the upload URLs acquired for the tracks of an
+// upload session, for the progress tracker.
+type uploadCount struct {
+	mu          sync.Mutex
+	done, total int
+}
+
+func (n *uploadCount) get() (done, total int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.done, n.total
+}
+
+func (n *uploadCount) add() {
+	n.mu.Lock()
+	n.done++
+	n.mu.Unlock()
+}
+
+// importTracks implements ImportTracksContext.  The upload URLs
+// acquired are counted with count, or if it is nil, against the
+// tracks of this call alone.
+func (c *Client) importTracks(ctx context.Context, tracks []*Track, count *uploadCount) (urls []string, errs []error) {
 	// Construct and the client-ID-to-track-index mapping and the
 	// initial metadata upload.
 	cidm := make(map[string]int)
@@ -151,21 +405,59 @@ func (c *Client) ImportTracks(tracks []*Track) (urls []string, errs []error) {
 			errs[i] = fmt.Errorf("trying to import two tracks with the same client-side ID")
 			continue
 		}
+		if track.ContentType.MIMEType() == "" {
+			errs[i] = fmt.Errorf("cannot import tracks of content type %v", track.ContentType)
+			continue
+		}
 		trks[i] = new(mmldpb.Track)
 		convert.Convert(trks[i], track)
+		if trks[i].ContentType == mmldpb.Track_DEFAULT_FORMAT {
+			trks[i].ContentType = mmldpb.Track_MP3
+		}
 		cidm[track.ClientId] = i
 	}
-	// Upload track metadata.
-	res, err := c.uploadMetadata(&mmuspb.UploadMetadataRequest{
-		UploaderId: c.id,
-		Track:      trks,
-	})
+	if p := c.Policy(); p != nil && (p.PauseUploads || p.Abort) {
+		err := &BackoffError{Abort: p.Abort}
+		if p.RetryInMinutes > 0 {
+			err.RetryIn = p.RetryAt().Sub(time.Now())
+		}
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+		}
+		return nil, errs
+	}
+	started := false
+	if c.ReportUploadState {
+		var err error
+		if started, err = c.startUpload(ctx); err != nil {
+			for i := range errs {
+				if errs[i] == nil {
+					errs[i] = err
+				}
+			}
+			return nil, errs
+		}
+	}
+	// Upload track metadata, leaving out the tracks that have
+	// already failed.
+	req := &mmuspb.UploadMetadataRequest{UploaderId: c.id}
+	for _, trk := range trks {
+		if trk != nil {
+			req.Track = append(req.Track, trk)
+		}
+	}
+	res, err := c.uploadMetadata(ctx, req)
 	if err != nil {
 		for i := range errs {
 			if errs[i] == nil {
 				errs[i] = err
 			}
 		}
+		if started {
+			addStopError(errs, c.stopUpload())
+		}
 		return nil, errs
 	}
 	// Satisfy any requests for track samples and append the
@@ -197,8 +489,14 @@ func (c *Client) ImportTracks(tracks []*Track) (urls []string, errs []error) {
 				SignedChallengeInfo: sci,
 				SampleFormat:        mmldpb.Track_MP3,
 			}
+			if art, url := tracks[j].AlbumArt, tracks[j].AlbumArtUrl; art != nil || url != "" {
+				spls[i].UserAlbumArt = &mmudpb.ImageUnion{
+					UserAlbumArt: art,
+					AlbumArtUrl:  url,
+				}
+			}
 		}
-		sres, err := c.uploadSample(&mmuspb.UploadSampleRequest{
+		sres, err := c.uploadSample(ctx, &mmuspb.UploadSampleRequest{
 			UploaderId:  c.id,
 			TrackSample: spls,
 		})
@@ -219,13 +517,20 @@ func (c *Client) ImportTracks(tracks []*Track) (urls []string, errs []error) {
 			errs[i] = ImportError(res.ResponseCode)
 			continue
 		}
+		if res.AlbumArtUrl != "" {
+			tracks[i].AlbumArtUrl = res.AlbumArtUrl
+		}
 		sidm[i] = res.ServerTrackId
 	}
 	// Acquire upload sessions.
 	urls = make([]string, len(tracks))
+	if count == nil {
+		count = &uploadCount{total: len(sidm)}
+	}
+	n := 0
 	for i, id := range sidm {
 		trk := tracks[i]
-		res, err := c.getUploadSession(&mmssjs.GetUploadSessionRequest{
+		req := &mmssjs.GetUploadSessionRequest{
 			Name:         id,
 			UploaderId:   c.id,
 			ClientId:     trk.ClientId,
@@ -234,8 +539,17 @@ func (c *Client) ImportTracks(tracks []*Track) (urls []string, errs []error) {
 			SyncNow:      true,
 			// BUG(lor): Client.ImportTracks does not
 			// activate the upload progress tracker on
-			// https://play.google.com/music.
-		})
+			// https://play.google.com/music unless
+			// Client.ReportUploadState is set, as only
+			// then are the track title and counts it
+			// displays sent.  The counts are of upload
+			// URLs acquired, not of tracks uploaded.
+		}
+		if c.ReportUploadState {
+			req.CurrentUploadingTrack = trk.Title
+			req.CurrentTotalUploadedCount, req.ClientTotalSongCount = count.get()
+		}
+		res, err := c.getUploadSession(ctx, req)
 		if err != nil {
 			errs[i] = err
 			continue
@@ -245,10 +559,53 @@ func (c *Client) ImportTracks(tracks []*Track) (urls []string, errs []error) {
 			continue
 		}
 		urls[i] = res.Transfers[0].PutUrl
+		count.add()
+		n++
+	}
+	if started && n == 0 {
+		addStopError(errs, c.stopUpload())
 	}
 	return urls, errs
 }
 
+// stopUploadTimeout bounds the time stopUpload waits for the server.
+const stopUploadTimeout = 30 * time.Second
+
+// startUpload declares the upload session started unless it already
+// is, and reports whether it did so.
+func (c *Client) startUpload(ctx context.Context) (bool, error) {
+	c.mu.Lock()
+	uploading := c.uploading
+	c.mu.Unlock()
+	if uploading {
+		return false, nil
+	}
+	return true, c.SetUploadStateContext(ctx, UploadStarted)
+}
+
+// stopUpload declares the upload session stopped.  A fresh context is
+// used, so that the stop is reported even if the upload was cancelled.
+func (c *Client) stopUpload() error {
+	ctx, cancel := context.WithTimeout(context.Background(), stopUploadTimeout)
+	defer cancel()
+	return c.SetUploadStateContext(ctx, UploadStopped)
+}
+
+// addStopError adds err, the error of stopping the upload session, to
+// the errors of a batch of tracks none of which could be uploaded.
+func addStopError(errs []error, err error) {
+	if err == nil {
+		return
+	}
+	for i := range errs {
+		if errs[i] == nil {
+			errs[i] = err
+		} else {
+			errs[i] = fmt.Errorf("%v (stopping the upload session also failed: %v)", errs[i], err)
+		}
+	}
+}
+
 // CheckImportResponse checks the response to an HTTP request sent to a
 // URL returned by Client.ImportTracks.  It returns the server ID of the
 // track to which resp is a response, or an error if the response is
@@ -267,3 +624,127 @@ func CheckImportResponse(resp *http.Response) (string, error) {
 		return res.Transfers[0].Name, nil
 	}
 }
+
+// ImportPlaylists creates the given playlists in the user's library and
+// returns their server IDs.  The playlists are told apart by their
+// ClientId fields, which must be unique.  If a playlist is rejected,
+// its error is of type PlaylistImportError; in the case of
+// ErrPlaylistAlreadyExists, the server ID of the existing playlist is
+// returned alongside it.
+func (c *Client) ImportPlaylists(playlists []*Playlist) (ids []string, errs []error) {
+	return c.ImportPlaylistsContext(context.Background(), playlists)
+}
+
+// ImportPlaylistsContext is like ImportPlaylists but takes a context.
+func (c *Client) ImportPlaylistsContext(ctx context.Context, playlists []*Playlist) (ids []string, errs []error) {
+	cidm := make(map[string]int)
+	pls := make([]*mmldpb.Playlist, 0, len(playlists))
+	errs = make([]error, len(playlists))
+	for i, p := range playlists {
+		if _, ok := cidm[p.ClientId]; ok {
+			errs[i] = fmt.Errorf("trying to import two playlists with the same client-side ID")
+			continue
+		}
+		pl := new(mmldpb.Playlist)
+		convert.Convert(pl, p)
+		pls = append(pls, pl)
+		cidm[p.ClientId] = i
+	}
+	res, err := c.uploadPlaylists(ctx, &mmuspb.UploadPlaylistRequest{
+		UploaderId: c.id,
+		UploadOperation: &mmudpb.UploadOperation{
+			Operation: mmudpb.UploadOperation_OPERATION_CREATE,
+		},
+		Playlist: pls,
+	})
+	if err != nil {
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+		}
+		return nil, errs
+	}
+	ids = make([]string, len(playlists))
+	for _, pres := range res.PlaylistResponse {
+		i, ok := cidm[pres.ClientId]
+		if !ok {
+			continue
+		}
+		ids[i], errs[i] = pres.ServerId, playlistImportError(pres.ResponseStatus)
+		delete(cidm, pres.ClientId)
+	}
+	for _, i := range cidm {
+		errs[i] = ErrPlaylistImportUnknown
+	}
+	return ids, errs
+}
+
+// ImportPlaylistEntries adds the given entries to the playlists named
+// by their PlaylistId fields and returns their server IDs.  The Track
+// fields of the entries are ignored.  The entries are told apart by
+// their ClientId fields, which must be unique, and errors are reported
+// as with ImportPlaylists.
+func (c *Client) ImportPlaylistEntries(entries []*PlaylistEntry) (ids []string, errs []error) {
+	return c.ImportPlaylistEntriesContext(context.Background(), entries)
+}
+
+// ImportPlaylistEntriesContext is like ImportPlaylistEntries but takes
+// a context.
+func (c *Client) ImportPlaylistEntriesContext(ctx context.Context, entries []*PlaylistEntry) (ids []string, errs []error) {
+	cidm := make(map[string]int)
+	ents := make([]*mmldpb.PlaylistEntry, 0, len(entries))
+	errs = make([]error, len(entries))
+	for i, e := range entries {
+		if _, ok := cidm[e.ClientId]; ok {
+			errs[i] = fmt.Errorf("trying to import two playlist entries with the same client-side ID")
+			continue
+		}
+		ent := new(mmldpb.PlaylistEntry)
+		convert.Convert(ent, e)
+		ent.Track = nil
+		ents = append(ents, ent)
+		cidm[e.ClientId] = i
+	}
+	res, err := c.uploadPlaylistEntries(ctx, &mmuspb.UploadPlaylistEntryRequest{
+		UploaderId: c.id,
+		UploadOperation: &mmudpb.UploadOperation{
+			Operation: mmudpb.UploadOperation_OPERATION_CREATE,
+		},
+		PlaylistEntry: ents,
+	})
+	if err != nil {
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+		}
+		return nil, errs
+	}
+	ids = make([]string, len(entries))
+	for _, eres := range res.PlaylistEntryResponse {
+		i, ok := cidm[eres.ClientId]
+		if !ok {
+			continue
+		}
+		ids[i], errs[i] = eres.ServerId, playlistImportError(eres.ResponseStatus)
+		delete(cidm, eres.ClientId)
+	}
+	for _, i := range cidm {
+		errs[i] = ErrPlaylistImportUnknown
+	}
+	return ids, errs
+}
+
+// playlistImportError returns the error corresponding to the given
+// response status, or nil if it signals success.
+func playlistImportError(s *mmudpb.ResponseStatus) error {
+	switch {
+	case s == nil:
+		return ErrPlaylistImportUnknown
+	case s.ResponseCode == mmudpb.ResponseStatus_OK:
+		return nil
+	default:
+		return PlaylistImportError(s.ResponseCode)
+	}
+}
@@ -0,0 +1,438 @@
+package musicmanager_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/lxr/go.google.musicmanager"
+	"github.com/lxr/go.google.musicmanager/musicmanagertest"
+)
+
+const deviceID = "01:23:45:67:89:ab"
+
+// newClient returns a client talking to s.
+func newClient(t *testing.T, s *musicmanagertest.Server) *musicmanager.Client {
+	t.Helper()
+	c, err := s.NewClient(deviceID)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return c
+}
+
+// importAndPost imports the given tracks and posts the given audio data
+// to their upload URLs, returning the server IDs of the tracks.
+func importAndPost(t *testing.T, c *musicmanager.Client, tracks []*musicmanager.Track, audio [][]byte) []string {
+	t.Helper()
+	urls, errs := c.ImportTracks(tracks)
+	ids := make([]string, len(tracks))
+	for i := range tracks {
+		if errs[i] != nil {
+			t.Fatalf("ImportTracks: track %d: %v", i, errs[i])
+		}
+		resp, err := http.Post(urls[i], "audio/mpeg", bytes.NewReader(audio[i]))
+		if err != nil {
+			t.Fatalf("posting track %d: %v", i, err)
+		}
+		if ids[i], err = musicmanager.CheckImportResponse(resp); err != nil {
+			t.Fatalf("CheckImportResponse: track %d: %v", i, err)
+		}
+	}
+	return ids
+}
+
+func TestRegister(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	c := newClient(t, s)
+	if err := c.Register("test device"); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if name := s.Devices()[deviceID]; name != "test device" {
+		t.Errorf("device registered as %q, want %q", name, "test device")
+	}
+}
+
+func TestImportTracks(t *testing.T) {
+	for _, samples := range []bool{false, true} {
+		t.Run(fmt.Sprintf("samples=%v", samples), func(t *testing.T) {
+			s := musicmanagertest.NewServer()
+			defer s.Close()
+			s.RequestSamples = samples
+			c := newClient(t, s)
+			var sampled []string
+			tracks := []*musicmanager.Track{
+				{ClientId: "a", Title: "A"},
+				{ClientId: "b", Title: "B"},
+			}
+			for _, track := range tracks {
+				title := track.Title
+				track.SampleFunc = func(start, duration int) []byte {
+					sampled = append(sampled, title)
+					return []byte("sample")
+				}
+			}
+			audio := [][]byte{[]byte("audio A"), []byte("audio B")}
+			ids := importAndPost(t, c, tracks, audio)
+			for i, id := range ids {
+				track, got, ok := s.Track(id)
+				if !ok {
+					t.Fatalf("track %s not in library", id)
+				}
+				if track.Title != tracks[i].Title || !bytes.Equal(got, audio[i]) {
+					t.Errorf("server has %q with audio %q, want %q with %q",
+						track.Title, got, tracks[i].Title, audio[i])
+				}
+			}
+			if samples && len(sampled) != len(tracks) {
+				t.Errorf("sampled %v", sampled)
+			} else if !samples && len(sampled) != 0 {
+				t.Errorf("sampled %v without being asked", sampled)
+			}
+			_, errs := c.ImportTracks(tracks[:1])
+			if errs[0] != musicmanager.ErrAlreadyExists {
+				t.Errorf("reimporting gave %v, want %v", errs[0], musicmanager.ErrAlreadyExists)
+			}
+		})
+	}
+}
+
+func TestImportTracksDuplicateClientId(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	c := newClient(t, s)
+	_, errs := c.ImportTracks([]*musicmanager.Track{
+		{ClientId: "a", Title: "A"},
+		{ClientId: "a", Title: "A again"},
+	})
+	if errs[0] != nil || errs[1] == nil {
+		t.Errorf("ImportTracks: errors %v, want only the second to fail", errs)
+	}
+}
+
+func TestListTracks(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	s.PageSize = 2
+	for _, title := range []string{"a", "b", "c", "d", "e"} {
+		s.AddTrack(&musicmanager.Track{Title: title}, nil)
+	}
+	c := newClient(t, s)
+	var n, pages int
+	var updatedMin int64
+	for token := ""; ; {
+		l, err := c.ListTracks(false, 0, token)
+		if err != nil {
+			t.Fatalf("ListTracks: %v", err)
+		}
+		n += len(l.Items)
+		pages++
+		updatedMin = l.UpdatedMin
+		if token = l.PageToken; token == "" {
+			break
+		}
+	}
+	if n != 5 || pages != 3 {
+		t.Errorf("listed %d tracks in %d pages, want 5 in 3", n, pages)
+	}
+	l, err := c.ListTracks(false, updatedMin, "")
+	if err != nil {
+		t.Fatalf("ListTracks: %v", err)
+	}
+	if len(l.Items) != 0 {
+		t.Errorf("listed %d tracks updated since the last listing, want 0", len(l.Items))
+	}
+}
+
+func TestExportTrack(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	id := s.AddTrack(&musicmanager.Track{Title: "song"}, []byte("audio"))
+	c := newClient(t, s)
+	u, err := c.ExportTrack(id)
+	if err != nil {
+		t.Fatalf("ExportTrack: %v", err)
+	}
+	resp, err := http.Get(u)
+	if err != nil {
+		t.Fatalf("GET %s: %v", u, err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading audio: %v", err)
+	}
+	if string(b) != "audio" {
+		t.Errorf("downloaded %q, want %q", b, "audio")
+	}
+	if _, err := c.ExportTrack("no such track"); err == nil {
+		t.Error("ExportTrack of a missing track succeeded")
+	}
+}
+
+func TestState(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	s.AddTrack(&musicmanager.Track{Title: "a"}, []byte("audio"))
+	c := newClient(t, s)
+	if _, errs := c.ImportTracks([]*musicmanager.Track{{ClientId: "p", Title: "P"}}); errs[0] != nil {
+		t.Fatalf("ImportTracks: %v", errs[0])
+	}
+	st, err := c.State()
+	if err != nil {
+		t.Fatalf("State: %v", err)
+	}
+	if st.TotalTrackCount != 2 || st.UserSongsInLocker != 1 {
+		t.Errorf("State: %d tracks with %d in locker, want 2 with 1", st.TotalTrackCount, st.UserSongsInLocker)
+	}
+}
+
+func TestPendingJobs(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	c := newClient(t, s)
+	if _, errs := c.ImportTracks([]*musicmanager.Track{{ClientId: "p", Title: "P"}}); errs[0] != nil {
+		t.Fatalf("ImportTracks: %v", errs[0])
+	}
+	jobs, err := c.PendingJobs()
+	if err != nil {
+		t.Fatalf("PendingJobs: %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].ClientId != "p" || jobs[0].Status != musicmanager.UploadRequested {
+		t.Errorf("PendingJobs: got %+v, want one upload request for p", jobs)
+	}
+	if err := c.DeleteUploadRequests(); err != nil {
+		t.Fatalf("DeleteUploadRequests: %v", err)
+	}
+	if jobs, _ := c.PendingJobs(); len(jobs) != 0 {
+		t.Errorf("PendingJobs after DeleteUploadRequests: got %+v", jobs)
+	}
+}
+
+func TestUploadState(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	c := newClient(t, s)
+	c.ReportUploadState = true
+	if _, errs := c.ImportTracks([]*musicmanager.Track{{ClientId: "p", Title: "P"}}); errs[0] != nil {
+		t.Fatalf("ImportTracks: %v", errs[0])
+	}
+	if state := s.UploadState(); state != musicmanager.UploadStarted {
+		t.Errorf("upload state is %v, want %v", state, musicmanager.UploadStarted)
+	}
+	if err := c.SetUploadState(musicmanager.UploadStopped); err != nil {
+		t.Fatalf("SetUploadState: %v", err)
+	}
+	if state := s.UploadState(); state != musicmanager.UploadStopped {
+		t.Errorf("upload state is %v, want %v", state, musicmanager.UploadStopped)
+	}
+}
+
+func TestContext(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	c := newClient(t, s)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.StateContext(ctx); err == nil {
+		t.Error("StateContext with a cancelled context succeeded")
+	}
+	if _, err := c.ListTracksContext(ctx, false, 0, ""); err == nil {
+		t.Error("ListTracksContext with a cancelled context succeeded")
+	}
+	if _, err := c.StateContext(context.Background()); err != nil {
+		t.Errorf("StateContext: %v", err)
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	c, err := musicmanager.NewClient(s.Client(), deviceID)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if c.Endpoints != musicmanager.DefaultEndpoints {
+		t.Errorf("new client has endpoints %+v, want %+v", c.Endpoints, musicmanager.DefaultEndpoints)
+	}
+	c.Endpoints = s.Endpoints()
+	if err := c.Register("test device"); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if _, ok := s.Devices()[deviceID]; !ok {
+		t.Error("device not registered with the server the endpoints point to")
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	c := newClient(t, s)
+	if p := c.Policy(); p != nil {
+		t.Errorf("Policy before any request: got %+v", p)
+	}
+	s.Policy = &musicmanager.ClientPolicy{
+		PauseUploads:     true,
+		RetryInMinutes:   5,
+		BandwidthCapKbps: 64,
+	}
+	if _, err := c.State(); err != nil {
+		t.Fatalf("State: %v", err)
+	}
+	p := c.Policy()
+	if p == nil || !p.PauseUploads || p.BandwidthCapKbps != 64 {
+		t.Fatalf("Policy: got %+v, want the server's", p)
+	}
+	_, errs := c.ImportTracks([]*musicmanager.Track{{ClientId: "a", Title: "A"}})
+	if e, ok := errs[0].(*musicmanager.BackoffError); !ok || e.Abort || e.RetryIn <= 0 {
+		t.Errorf("ImportTracks while paused: got %v, want a pause", errs[0])
+	}
+	// A pause without a retry interval stays in force until the
+	// server sends a new policy.
+	s.Policy = &musicmanager.ClientPolicy{PauseUploads: true}
+	if _, err := c.State(); err != nil {
+		t.Fatalf("State: %v", err)
+	}
+	if p := c.Policy(); p == nil || !p.PauseUploads {
+		t.Errorf("Policy with an open-ended pause: got %+v, want a pause", p)
+	}
+	_, errs = c.ImportTracks([]*musicmanager.Track{{ClientId: "a", Title: "A"}})
+	if e, ok := errs[0].(*musicmanager.BackoffError); !ok || e.RetryIn != 0 {
+		t.Errorf("ImportTracks while paused indefinitely: got %v, want a pause", errs[0])
+	}
+	s.Policy = &musicmanager.ClientPolicy{}
+	if _, err := c.State(); err != nil {
+		t.Fatalf("State: %v", err)
+	}
+	if p := c.Policy(); p == nil || p.PauseUploads {
+		t.Errorf("Policy after the pause was lifted: got %+v", p)
+	}
+	if _, errs := c.ImportTracks([]*musicmanager.Track{{ClientId: "a", Title: "A"}}); errs[0] != nil {
+		t.Errorf("ImportTracks after the pause was lifted: %v", errs[0])
+	}
+}
+
+func TestImportPlaylists(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	a := s.AddTrack(&musicmanager.Track{Title: "a"}, []byte("aa"))
+	c := newClient(t, s)
+
+	ids, errs := c.ImportPlaylists([]*musicmanager.Playlist{
+		{ClientId: "p1", Name: "one"},
+		{ClientId: "p1", Name: "one again"},
+	})
+	if errs[0] != nil || ids[0] == "" {
+		t.Fatalf("ImportPlaylists: got ID %q and error %v", ids[0], errs[0])
+	}
+	if errs[1] == nil {
+		t.Errorf("ImportPlaylists: importing a duplicate client ID succeeded")
+	}
+	again, errs := c.ImportPlaylists([]*musicmanager.Playlist{{ClientId: "p1", Name: "one"}})
+	if again[0] != ids[0] || errs[0] != musicmanager.ErrPlaylistAlreadyExists {
+		t.Errorf("reimporting: got ID %q and error %v, want %q and %v",
+			again[0], errs[0], ids[0], musicmanager.ErrPlaylistAlreadyExists)
+	}
+
+	eids, errs := c.ImportPlaylistEntries([]*musicmanager.PlaylistEntry{
+		{ClientId: "e1", PlaylistId: ids[0], TrackId: a},
+		{ClientId: "e2", PlaylistId: "no such playlist", TrackId: a},
+	})
+	if errs[0] != nil || eids[0] == "" {
+		t.Fatalf("ImportPlaylistEntries: got ID %q and error %v", eids[0], errs[0])
+	}
+	if errs[1] != musicmanager.ErrPlaylistSoftError {
+		t.Errorf("ImportPlaylistEntries into a missing playlist: got %v, want %v", errs[1], musicmanager.ErrPlaylistSoftError)
+	}
+	el, err := c.ListPlaylistEntries(ids[0], 0, "")
+	if err != nil {
+		t.Fatalf("ListPlaylistEntries: %v", err)
+	}
+	if len(el.Items) != 1 || el.Items[0].Id != eids[0] {
+		t.Errorf("ListPlaylistEntries: got %+v, want entry %s", el.Items, eids[0])
+	}
+}
+
+func TestImportTracksAlbumArt(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	c := newClient(t, s)
+	tracks := []*musicmanager.Track{
+		{ClientId: "a", Title: "a", AlbumArt: []byte("PNG")},
+		{ClientId: "b", Title: "b", AlbumArtUrl: "http://example.com/b.jpg"},
+		{ClientId: "c", Title: "c"},
+		{ClientId: "d", Title: "d", AlbumArt: []byte("dropped")},
+	}
+	// Art is only sent with samples, so the last track is imported
+	// without.
+	if _, errs := c.ImportTracks(tracks[3:]); errs[0] != nil {
+		t.Fatalf("ImportTracks: %v", errs[0])
+	}
+	s.RequestSamples = true
+	_, errs := c.ImportTracks(tracks[:3])
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("ImportTracks: track %d: %v", i, err)
+		}
+	}
+	if tracks[0].AlbumArtUrl == "" {
+		t.Fatal("ImportTracks did not set the URL of uploaded art")
+	}
+	if tracks[1].AlbumArtUrl != "http://example.com/b.jpg" || tracks[2].AlbumArtUrl != "" || tracks[3].AlbumArtUrl != "" {
+		t.Errorf("ImportTracks set art URLs %q, %q and %q, want %q, %q and %q",
+			tracks[1].AlbumArtUrl, tracks[2].AlbumArtUrl, tracks[3].AlbumArtUrl, "http://example.com/b.jpg", "", "")
+	}
+	resp, err := s.Client().Get(tracks[0].AlbumArtUrl)
+	if err != nil {
+		t.Fatalf("GET %s: %v", tracks[0].AlbumArtUrl, err)
+	}
+	defer resp.Body.Close()
+	if b, _ := ioutil.ReadAll(resp.Body); string(b) != "PNG" {
+		t.Errorf("server has art %q, want %q", b, "PNG")
+	}
+	l, err := c.LookupTracksByClientId("a")
+	if err != nil {
+		t.Fatalf("LookupTracksByClientId: %v", err)
+	}
+	if l[0] == nil || l[0].AlbumArtUrl != tracks[0].AlbumArtUrl {
+		t.Errorf("looked up %+v, want art URL %q", l[0], tracks[0].AlbumArtUrl)
+	}
+}
+
+func TestImportTracksContentType(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	c := newClient(t, s)
+	tracks := []*musicmanager.Track{
+		{ClientId: "a", Title: "a", ContentType: musicmanager.FLAC},
+		{ClientId: "b", Title: "b", ContentType: musicmanager.M4P},
+		{ClientId: "c", Title: "c"},
+	}
+	_, errs := c.ImportTracks(tracks)
+	if errs[0] != nil || errs[1] == nil || errs[2] != nil {
+		t.Fatalf("ImportTracks: got errors %v, want only M4P to fail", errs)
+	}
+	l, err := c.LookupTracksByClientId("a", "c")
+	if err != nil {
+		t.Fatalf("LookupTracksByClientId: %v", err)
+	}
+	if l[0].ContentType != musicmanager.FLAC || l[1].ContentType != musicmanager.MP3 {
+		t.Errorf("imported as %v and %v, want %v and %v", l[0].ContentType, l[1].ContentType, musicmanager.FLAC, musicmanager.MP3)
+	}
+	// Samples are MP3 whatever the format of the track.
+	s.RequestSamples = true
+	_, errs = c.ImportTracks([]*musicmanager.Track{{ClientId: "d", Title: "d", ContentType: musicmanager.FLAC}})
+	if errs[0] != nil {
+		t.Errorf("ImportTracks of a sampled FLAC track: %v", errs[0])
+	}
+	if mt := musicmanager.FLAC.MIMEType(); mt != "audio/flac" {
+		t.Errorf("FLAC.MIMEType() = %q, want %q", mt, "audio/flac")
+	}
+	if mt := musicmanager.M4P.MIMEType(); mt != "" {
+		t.Errorf("M4P.MIMEType() = %q, want %q", mt, "")
+	}
+}
@@ -55,3 +55,33 @@ func logf(format string, args ...interface{}) {
 func println(s string) {
 	fmt.Println(s)
 }
+
+// parallel calls work(i) for each i from 0 to count-1, with up to n
+// calls in progress at once.  It calls report(i) for each i in order
+// as soon as work(i) and all the calls to report before it have
+// returned, so that output written by report keeps the order of the
+// input.
+func parallel(n, count int, work, report func(i int)) {
+	if n < 1 {
+		n = 1
+	}
+	done := make([]chan struct{}, count)
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	sem := make(chan struct{}, n)
+	go func() {
+		for i := 0; i < count; i++ {
+			sem <- struct{}{}
+			go func(i int) {
+				work(i)
+				<-sem
+				close(done[i])
+			}(i)
+		}
+	}()
+	for i := 0; i < count; i++ {
+		<-done[i]
+		report(i)
+	}
+}
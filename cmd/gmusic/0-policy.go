@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/lxr/go.google.musicmanager"
+)
+
+// policyRefreshInterval is how often waitPolicy asks the server for a
+// new client policy.  In between, it uses the policy recorded from the
+// responses to earlier requests.
+const policyRefreshInterval = time.Minute
+
+var (
+	policyMu        sync.Mutex
+	policyRefreshed time.Time
+)
+
+// refreshPolicy asks the server for a new client policy if the last
+// request for one was made more than policyRefreshInterval ago.  Only
+// one of any concurrent callers makes the request.
+func refreshPolicy(client *musicmanager.Client) error {
+	policyMu.Lock()
+	stale := time.Since(policyRefreshed) >= policyRefreshInterval
+	if stale {
+		policyRefreshed = time.Now()
+	}
+	policyMu.Unlock()
+	if !stale {
+		return nil
+	}
+	if _, err := client.State(); err != nil {
+		policyMu.Lock()
+		policyRefreshed = time.Time{}
+		policyMu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// waitPolicy sleeps for as long as the client policy of the given
+// client asks uploads (or downloads, if downloads is true) to be
+// paused, refreshing the policy as needed.  It returns the policy in
+// effect afterwards, or an error if the server asks the client to
+// abort.  The policy is not refreshed while a track is being
+// transferred, so waitPolicy should be called before each track.
+func waitPolicy(client *musicmanager.Client, downloads bool) (*musicmanager.ClientPolicy, error) {
+	for {
+		if err := refreshPolicy(client); err != nil {
+			return nil, err
+		}
+		p := client.Policy()
+		if p == nil {
+			return new(musicmanager.ClientPolicy), nil
+		}
+		if p.Abort {
+			return nil, errors.New("server requested to abort")
+		}
+		paused := p.PauseUploads
+		if downloads {
+			paused = p.PauseDownloads
+		}
+		if !paused {
+			return p, nil
+		}
+		d := p.RetryAt().Sub(time.Now())
+		if d < time.Minute {
+			d = time.Minute
+		}
+		logf("server requested to pause, retrying in %v\n", d)
+		time.Sleep(d)
+	}
+}
+
+// bandwidthCap returns the lower of the given rate and the upload (or
+// download, if downloads is true) bandwidth cap in the most recent
+// policy of the given client, in kilobits per second.  Non-positive
+// values mean no limit.  The policy is not refreshed, so the cap is only
+// as recent as the last call to waitPolicy.
+func bandwidthCap(client *musicmanager.Client, kbps int, downloads bool) int {
+	p := client.Policy()
+	if p == nil {
+		return kbps
+	}
+	limit := p.BandwidthCapKbps
+	if downloads {
+		limit = p.DownloadBandwidthCapKbps
+	}
+	if limit > 0 && (kbps <= 0 || limit < kbps) {
+		return limit
+	}
+	return kbps
+}
+
+// A limiter limits the rate at which data passes through it.  A nil
+// limiter or one with a non-positive rate imposes no limit.
+type limiter struct {
+	mu    sync.Mutex
+	rate  int64 // bytes per second
+	start time.Time
+	n     int64
+}
+
+// newLimiter returns a limiter with a rate of the given number of
+// kilobits per second.
+func newLimiter(kbps int) *limiter {
+	return &limiter{rate: int64(kbps) * 1000 / 8}
+}
+
+// setRate changes the rate of l to the given number of kilobits per
+// second.  The average is measured anew from the change.
+func (l *limiter) setRate(kbps int) {
+	rate := int64(kbps) * 1000 / 8
+	l.mu.Lock()
+	if rate != l.rate {
+		l.rate = rate
+		l.start = time.Time{}
+		l.n = 0
+	}
+	l.mu.Unlock()
+}
+
+// wait records the passing of n bytes through l, sleeping as long as
+// necessary to keep the average rate within the limit.
+func (l *limiter) wait(n int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return
+	}
+	if l.start.IsZero() {
+		l.start = time.Now()
+	}
+	l.n += int64(n)
+	t := l.start.Add(time.Duration(l.n) * time.Second / time.Duration(l.rate))
+	l.mu.Unlock()
+	time.Sleep(t.Sub(time.Now()))
+}
+
+// A limitedWriterAt passes writes through a limiter.
+type limitedWriterAt struct {
+	w io.WriterAt
+	l *limiter
+}
+
+func (w *limitedWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	n, err := w.w.WriteAt(p, off)
+	w.l.wait(n)
+	return n, err
+}
+
+// A limitedReaderAt passes reads through a limiter.
+type limitedReaderAt struct {
+	r io.ReaderAt
+	l *limiter
+}
+
+func (r *limitedReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.r.ReadAt(p, off)
+	r.l.wait(n)
+	return n, err
+}
@@ -29,6 +29,11 @@ the server only checks for a colon.
 If a human-readable name under which to register gmusic is not given,
 it defaults to "gmusic".
 
+The albums, artists, genres, stats and mix commands are experimental.
+They use the locker service, whose location is not publicly known, and
+fail unless the environment variable GMUSIC_LOCKER is set to the base
+URL of the service.
+
 */
 package main
 
@@ -137,6 +142,10 @@ func loadClient() (*musicmanager.Client, error) {
 	if err := json.NewDecoder(f).Decode(&creds); err != nil {
 		return nil, err
 	}
-	client := conf.Client(oauth2.NoContext, &creds.Token)
-	return musicmanager.NewClient(client, creds.ID)
+	client, err := musicmanager.NewClient(conf.Client(oauth2.NoContext, &creds.Token), creds.ID)
+	if err != nil {
+		return nil, err
+	}
+	client.Endpoints.Locker = os.Getenv("GMUSIC_LOCKER")
+	return client, nil
 }
@@ -75,25 +75,16 @@ func list() error {
 	if err != nil {
 		return err
 	}
-	pageToken := ""
-
 	client, err := loadClient()
 	if err != nil {
 		return err
 	}
-	for {
-		list, err := client.ListTracks(*purchasedOnly, updatedMin, pageToken)
+	it := client.Tracks(*purchasedOnly, updatedMin)
+	for it.Next() {
+		err = listTpls.ExecuteTemplate(os.Stdout, "tracklist", it.TrackList())
 		if err != nil {
 			return err
 		}
-		err = listTpls.ExecuteTemplate(os.Stdout, "tracklist", list)
-		if err != nil {
-			return err
-		}
-		pageToken = list.PageToken
-		if pageToken == "" {
-			break
-		}
 	}
-	return nil
+	return it.Err()
 }
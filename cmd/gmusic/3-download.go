@@ -4,7 +4,7 @@ Downloading tracks
 
 Usage:
 
-	gmusic download [id...]
+	gmusic download [-j jobs] [-l kbps] [id...]
 
 Download downloads the tracks identified by the IDs to the current
 directory and prints the filenames they are saved under to standard
@@ -15,22 +15,33 @@ from standard input.
 If a track fails to download, download moves on to the next one.  The
 exit status is 0 only if all tracks downloaded successfully.
 
+The -j flag sets the number of tracks downloaded at once; the default is
+1.  The filenames are printed in the order of the IDs regardless.
+
+The -l flag limits the combined bandwidth of the downloads to the given
+number of kilobits per second.  The default, 0, means no limit.
+
 The filename under which each track is saved is generated server-side
 from the track's title and track number.  Download clobbers existing
 files without asking, so be careful with it.
 
+Download obeys the server's requests to pause downloads and limit their
+bandwidth, using the lower of the server's limit and the one given with
+-l.  The server's policy is checked before each track.
+
+A track is downloaded into a file named after its ID with the suffix
+.part and renamed once complete.  An interrupted download is resumed
+from where it stopped, both within one run and, if the .part file is
+left behind, by a later run.
+
 */
 package main
 
 import (
 	"errors"
-	"io"
-	"net/http"
-	"net/url"
+	"flag"
 	"os"
 	"path/filepath"
-	"strconv"
-	"strings"
 
 	"github.com/lxr/go.google.musicmanager"
 )
@@ -40,72 +51,85 @@ func init() {
 }
 
 func download() error {
+	var (
+		jobs = flag.Int("j", 1, "number of tracks to download at once")
+		kbps = flag.Int("l", 0, "bandwidth limit in kbps")
+	)
+	flag.Parse()
+	// Leave only the IDs for getScanner.
+	os.Args = append(os.Args[:1], flag.Args()...)
 	client, err := loadClient()
 	if err != nil {
 		return err
 	}
-	success := true
+	var ids []string
 	scanner := getScanner()
 	for scanner.Scan() {
-		id := scanner.Text()
-		logf("downloading %s: ", id)
-		name, err := downloadTrack(client, id)
-		if err != nil {
-			logf("%v\n", err)
+		ids = append(ids, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if _, err := waitPolicy(client, true); err != nil {
+		return err
+	}
+	l := newLimiter(bandwidthCap(client, *kbps, true))
+	names := make([]string, len(ids))
+	sizes := make([]int64, len(ids))
+	errs := make([]error, len(ids))
+	success := true
+	parallel(*jobs, len(ids), func(i int) {
+		if _, errs[i] = waitPolicy(client, true); errs[i] != nil {
+			return
+		}
+		l.setRate(bandwidthCap(client, *kbps, true))
+		names[i], sizes[i], errs[i] = downloadTrack(client, ids[i], l)
+	}, func(i int) {
+		logf("downloading %s: ", ids[i])
+		if errs[i] != nil {
+			logf("%v\n", errs[i])
 			success = false
 		} else {
-			println(name)
+			logf("(%.2f MiB) ", float64(sizes[i])/(1<<20))
+			println(names[i])
 		}
-	}
-	switch {
-	case scanner.Err() != nil:
-		return scanner.Err()
-	case !success:
+	})
+	if !success {
 		return errors.New("not all tracks were downloaded")
-	default:
-		return nil
 	}
+	return nil
 }
 
-func downloadTrack(client *musicmanager.Client, id string) (string, error) {
-	url, err := client.ExportTrack(id)
+func downloadTrack(client *musicmanager.Client, id string, l *limiter) (string, int64, error) {
+	part := id + ".part"
+	f, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
-	resp, err := http.Get(url)
+	name, n, err := client.Download(id, &limitedWriterAt{f, l}, fi.Size())
 	if err != nil {
-		return "", err
+		return "", 0, err
+	}
+	// A partial file left behind by an earlier run may be longer
+	// than the track, in which case the track was downloaded again
+	// from the beginning.
+	if err := f.Truncate(n); err != nil {
+		return "", 0, err
 	}
-	defer resp.Body.Close()
-	size, err := strconv.ParseFloat(resp.Header.Get("Content-Length"), 64)
-	if err == nil {
-		logf("(%.2f MiB) ", size/(1<<20))
+	if err := f.Close(); err != nil {
+		return "", 0, err
 	}
 	// Google probably won't put malicious paths in
 	// Content-Disposition, but this will prevent the file from
 	// being written outside the current directory.
-	name, _ := getName(resp.Header.Get("Content-Disposition"))
 	name = filepath.Base(name)
 	if name == "." {
 		name = id + ".mp3"
 	}
-	f, err := os.Create(name)
-	if err != nil {
-		return "", err
-	}
-	defer f.Close()
-	_, err = io.Copy(f, resp.Body)
-	return name, err
-}
-
-// getName extracts a UTF-8-encoded filename from a Content-Disposition
-// header.  (The default mime.ParseMediaType function cannot be used,
-// because it seems to disagree with the server on how to encode/decode
-// parens.)
-func getName(v string) (string, error) {
-	parts := strings.SplitN(v, "filename*=UTF-8''", 2)
-	if len(parts) < 2 {
-		return "", errors.New("media type value lacks UTF-8 filename")
-	}
-	return url.QueryUnescape(parts[1])
+	return name, n, os.Rename(part, name)
 }
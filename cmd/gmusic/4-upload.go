@@ -4,28 +4,58 @@ Uploading tracks
 
 Usage:
 
-	gmusic upload [file...]
+	gmusic upload [-j jobs] [-l kbps] [file...]
 
-Upload uploads the named MP3 files to the user's Google Play Music
+Upload uploads the named audio files to the user's Google Play Music
 library and prints their server-side IDs to standard output.  Progress
 and error information is additionally printed to standard error.  If
 no filenames are given, upload reads a newline-separated list from
-standard input.
+standard input.  Pictures embedded in the files' tags are uploaded as
+album art if the server asks for them.
+
+The format of a file is determined from its tags, or failing that, from
+its extension.  MP3, AAC, M4A, ALAC, FLAC, Ogg and WMA files can be
+uploaded; files in other formats are rejected before anything is sent
+to the server.
+
+If the server asks for a sample of a file to match it against its
+catalogue, upload cuts the requested part out of the file and sends it
+as 128 kbps MP3.  MP3 files are re-encoded with ffmpeg if they are not
+already 128 kbps and ffmpeg is installed.  Files in other formats can
+only be sampled with ffmpeg; without it, the server is sent an empty
+sample, and it will most likely refuse the file.
 
 If a file fails to upload, upload moves on to the next one.  The exit
 status is 0 only if all tracks uploaded successfully.
 
+The -j flag sets the number of tracks uploaded at once; the default is
+1.  The IDs are printed in the order of the files regardless.
+
+The -l flag limits the combined bandwidth of the uploads to the given
+number of kilobits per second.  The default, 0, means no limit.
+
+Upload obeys the server's requests to pause uploads and limit their
+bandwidth, using the lower of the server's limit and the one given with
+-l.  The server's policy is checked before each track.  An upload
+interrupted by a network or server error is resumed from where it
+stopped.
+
 */
 package main
 
 import (
 	"errors"
+	"flag"
 	"io"
-	"net/http"
+	"math"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 
 	"github.com/dhowden/tag"
 	"github.com/lxr/go.google.musicmanager"
+	"github.com/lxr/go.google.musicmanager/mp3sample"
 )
 
 func init() {
@@ -33,17 +63,26 @@ func init() {
 }
 
 func upload() error {
+	var (
+		jobs = flag.Int("j", 1, "number of tracks to upload at once")
+		kbps = flag.Int("l", 0, "bandwidth limit in kbps")
+	)
+	flag.Parse()
+	// Leave only the filenames for getScanner.
+	os.Args = append(os.Args[:1], flag.Args()...)
 	client, err := loadClient()
 	if err != nil {
 		return err
 	}
+	_, err = exec.LookPath("ffmpeg")
+	haveFFmpeg := err == nil
 	tracks := make([]*musicmanager.Track, 0)
 	files := make([]*os.File, 0)
 	success := true
 	scanner := getScanner()
 	for scanner.Scan() {
 		name := scanner.Text()
-		track, file, err := parseTrack(name)
+		track, file, err := parseTrack(name, haveFFmpeg)
 		if err != nil {
 			logf("reading %s: %v\n", name, err)
 			success = false
@@ -56,19 +95,36 @@ func upload() error {
 	if err := scanner.Err(); err != nil {
 		return err
 	}
-	urls, errs := client.ImportTracks(tracks)
-	for i, err := range errs {
-		logf("uploading %s: ", files[i].Name())
-		var id string
-		if err == nil {
-			id, err = uploadTrack(urls[i], files[i])
+	if _, err := waitPolicy(client, false); err != nil {
+		return err
+	}
+	l := newLimiter(bandwidthCap(client, *kbps, false))
+	// Start the upload session here, so that it spans all the
+	// calls to Upload rather than each of them starting and
+	// stopping their own.
+	client.ReportUploadState = true
+	if err := client.SetUploadState(musicmanager.UploadStarted); err != nil {
+		return err
+	}
+	ids := make([]string, len(tracks))
+	errs := make([]error, len(tracks))
+	parallel(*jobs, len(tracks), func(i int) {
+		if _, errs[i] = waitPolicy(client, false); errs[i] != nil {
+			return
 		}
-		if err != nil {
-			logf("%v\n", err)
+		l.setRate(bandwidthCap(client, *kbps, false))
+		ids[i], errs[i] = uploadTrack(client, tracks[i], files[i], l)
+	}, func(i int) {
+		logf("uploading %s: ", files[i].Name())
+		if errs[i] != nil {
+			logf("%v\n", errs[i])
 			success = false
 		} else {
-			println(id)
+			println(ids[i])
 		}
+	})
+	if err := client.SetUploadState(musicmanager.UploadStopped); err != nil {
+		logf("stopping the upload session: %v\n", err)
 	}
 	if !success {
 		return errors.New("not all files were uploaded")
@@ -76,18 +132,22 @@ func upload() error {
 	return nil
 }
 
-func uploadTrack(url string, r io.Reader) (string, error) {
-	// BUG(lor): Gmusic does not actually detect and report an error
-	// on (most) non-MP3 files.  All files are uploaded to Google
-	// Play, but only MP3 ones will be playable.
-	resp, err := http.Post(url, "audio/mpeg", r)
+// uploadTrack uploads the given track with its audio read from f
+// through l.
+func uploadTrack(client *musicmanager.Client, track *musicmanager.Track, f *os.File, l *limiter) (string, error) {
+	fi, err := f.Stat()
 	if err != nil {
 		return "", err
 	}
-	return musicmanager.CheckImportResponse(resp)
+	ids, errs, _ := client.Upload([]musicmanager.UploadItem{{
+		Track: track,
+		Audio: &limitedReaderAt{f, l},
+		Size:  fi.Size(),
+	}})
+	return ids[0], errs[0]
 }
 
-func parseTrack(name string) (track *musicmanager.Track, f *os.File, err error) {
+func parseTrack(name string, haveFFmpeg bool) (track *musicmanager.Track, f *os.File, err error) {
 	f, err = os.Open(name)
 	if err != nil {
 		return
@@ -98,15 +158,24 @@ func parseTrack(name string) (track *musicmanager.Track, f *os.File, err error)
 	}
 	metadata, err := tag.ReadFrom(f)
 	if err = rewind(f, err); err == tag.ErrNoTagsFound {
-		err = nil
+		var ct musicmanager.ContentType
+		if ct, err = contentType(name, tag.UnknownFileType); err != nil {
+			return
+		}
 		track = &musicmanager.Track{
-			ClientId: sum,
-			Title:    name,
+			ClientId:    sum,
+			Title:       name,
+			ContentType: ct,
+			SampleFunc:  sampleFunc(f, ct, haveFFmpeg),
 		}
 		return
 	} else if err != nil {
 		return
 	}
+	ct, err := contentType(name, metadata.FileType())
+	if err != nil {
+		return
+	}
 	ti, tn := metadata.Track()
 	di, dn := metadata.Disc()
 	track = &musicmanager.Track{
@@ -122,10 +191,80 @@ func parseTrack(name string) (track *musicmanager.Track, f *os.File, err error)
 		TotalTrackCount: tn,
 		DiscNumber:      di,
 		TotalDiscCount:  dn,
+		ContentType:     ct,
+		SampleFunc:      sampleFunc(f, ct, haveFFmpeg),
+	}
+	if p := metadata.Picture(); p != nil {
+		track.AlbumArt = p.Data
 	}
 	return
 }
 
+// sampleFunc returns a SampleFunc that cuts samples out of f, using
+// ffmpeg if haveFFmpeg is true.  Samples of files other than MP3 need
+// ffmpeg; without it, the returned function reports the problem and
+// returns no sample.
+func sampleFunc(f *os.File, ct musicmanager.ContentType, haveFFmpeg bool) func(start, duration int) []byte {
+	return func(start, duration int) []byte {
+		var sample []byte
+		var err error
+		switch {
+		case ct == musicmanager.MP3:
+			var t mp3sample.Transcoder
+			if haveFFmpeg {
+				t = mp3sample.FFmpeg
+			}
+			r := io.NewSectionReader(f, 0, math.MaxInt64)
+			sample, err = mp3sample.Sample(r, start, duration, t)
+		case haveFFmpeg:
+			sample, err = mp3sample.SampleFile(f.Name(), start, duration)
+		default:
+			err = errors.New("cannot sample non-MP3 files without ffmpeg")
+		}
+		if err != nil {
+			logf("sampling %s: %v\n", f.Name(), err)
+			return nil
+		}
+		return sample
+	}
+}
+
+// contentType returns the content type of the named file of type ft.
+// If ft is unknown, the content type is guessed from the file's
+// extension.
+func contentType(name string, ft tag.FileType) (musicmanager.ContentType, error) {
+	switch ft {
+	case tag.MP3:
+		return musicmanager.MP3, nil
+	case tag.M4A, tag.M4B:
+		return musicmanager.M4A, nil
+	case tag.M4P:
+		return musicmanager.M4P, nil
+	case tag.ALAC:
+		return musicmanager.ALAC, nil
+	case tag.FLAC:
+		return musicmanager.FLAC, nil
+	case tag.OGG:
+		return musicmanager.OGG, nil
+	case tag.UnknownFileType:
+		switch strings.ToLower(filepath.Ext(name)) {
+		case ".mp3":
+			return musicmanager.MP3, nil
+		case ".m4a", ".m4b", ".mp4":
+			return musicmanager.M4A, nil
+		case ".aac":
+			return musicmanager.AAC, nil
+		case ".flac":
+			return musicmanager.FLAC, nil
+		case ".ogg", ".oga":
+			return musicmanager.OGG, nil
+		case ".wma":
+			return musicmanager.WMA, nil
+		}
+	}
+	return 0, errors.New("unsupported file type: " + filepath.Base(name))
+}
+
 func rewind(s io.Seeker, err error) error {
 	_, err1 := s.Seek(0, os.SEEK_SET)
 	if err == nil {
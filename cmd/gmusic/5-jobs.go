@@ -0,0 +1,68 @@
+/*
+
+Listing pending uploads
+
+Usage:
+
+	gmusic jobs [-f format]
+
+Jobs lists the tracks the server is waiting for gmusic to upload in the
+following format:
+
+	UPLOAD_REQUESTED	b7c9a0e1-2d6f-3b0a-9c4e-5f1d2a3b4c5d	5d0f1a2b3c4d5e6f7a8b9c0d1e2f3a4b
+	FORCE_REUPLOAD	0a1b2c3d-4e5f-3a6b-8c7d-9e0f1a2b3c4d	c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9
+	...
+
+The first column is the reason for the request, the second the
+server-side ID of the track and the third its client-side ID; for
+tracks uploaded with gmusic, the latter is the checksum of the file's
+audio data.
+
+The -f flag can be used to specify an alternative format for the list,
+using the syntax of text/template.  The default format is
+"{{.Status}}\t{{.ServerId}}\t{{.ClientId}}\n".  The struct passed to the
+template is:
+
+	type Job struct {
+		ClientId string
+		ServerId string
+		Status   JobStatus
+	}
+
+*/
+package main
+
+import (
+	"flag"
+	"os"
+	"text/template"
+)
+
+func init() {
+	cmds["jobs"] = jobs
+}
+
+func jobs() error {
+	formatStr := flag.String("f", "{{.Status}}\t{{.ServerId}}\t{{.ClientId}}\n",
+		"alternative format for job listing")
+	flag.Parse()
+	tpl, err := template.New("job").Parse(*formatStr)
+	if err != nil {
+		return err
+	}
+
+	client, err := loadClient()
+	if err != nil {
+		return err
+	}
+	jobs, err := client.PendingJobs()
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		if err := tpl.Execute(os.Stdout, job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+/*
+
+Browsing albums, artists and genres
+
+Usage:
+
+	gmusic albums [-f format] [-s attribute] [-r] [-n max]
+	gmusic artists [-f format] [-r] [-n max]
+	gmusic genres [-f format] [-r] [-n max]
+
+Albums, artists and genres list the albums, artists and genres in the
+user's Google Play Music library in the following format:
+
+	12	Kasabian
+	3	The Pillows
+	...
+
+The first column is the number of tracks and the second the name of the
+album, artist or genre.
+
+The -f flag can be used to specify an alternative format for the list,
+using the syntax of text/template.  The default formats are
+"{{.TrackCount}}\t{{.Name}}\n" for albums and
+"{{.TotalTrackCount}}\t{{.Name}}\n" for artists and genres.  The structs
+passed to the templates are:
+
+	type Album struct {
+		Name              string
+		AlbumArtist       string
+		Artist            string
+		TrackCount        int
+		IsCompilation     bool
+		AlbumMetajamId    string
+		LastTimePlayed    int64
+		CreationTimestamp int64
+	}
+
+	type Artist struct {
+		Name            string
+		TotalTrackCount int
+		Albums          []*Album
+	}
+
+	type Genre struct {
+		Name            string
+		TotalTrackCount int
+		Albums          []*Album
+	}
+
+The -s flag selects the attribute by which albums are sorted: one of
+"name", "played" or "created".  The default is "name".  Artists and
+genres are always sorted by name.
+
+The -r flag reverses the sort order.
+
+The -n flag limits the listing to the given number of items.  The
+default, 0, means no limit.
+
+Albums, artists and genres are experimental; see gmusic register.
+
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/template"
+
+	"github.com/lxr/go.google.musicmanager"
+)
+
+var albumSortAttributes = map[string]musicmanager.AlbumSortAttribute{
+	"name":    musicmanager.SortAlbumName,
+	"played":  musicmanager.SortAlbumLastPlayed,
+	"created": musicmanager.SortAlbumCreationTime,
+}
+
+func init() {
+	cmds["albums"] = albums
+	cmds["artists"] = artists
+	cmds["genres"] = genres
+}
+
+func albums() error {
+	var (
+		formatStr = flag.String("f", "{{.TrackCount}}\t{{.Name}}\n",
+			"alternative format for album listing")
+		sortStr = flag.String("s", "name",
+			"sort albums by name, played or created")
+		descending = flag.Bool("r", false, "reverse the sort order")
+		maxResults = flag.Int("n", 0, "list at most this many albums")
+	)
+	flag.Parse()
+	sortBy, ok := albumSortAttributes[*sortStr]
+	if !ok {
+		return fmt.Errorf("unknown sort attribute %q", *sortStr)
+	}
+	tpl, err := template.New("album").Parse(*formatStr)
+	if err != nil {
+		return err
+	}
+
+	client, err := loadClient()
+	if err != nil {
+		return err
+	}
+	albums, err := client.ListAlbums(sortBy, *descending, *maxResults)
+	if err != nil {
+		return err
+	}
+	for _, album := range albums {
+		if err := tpl.Execute(os.Stdout, album); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func artists() error {
+	var (
+		formatStr = flag.String("f", "{{.TotalTrackCount}}\t{{.Name}}\n",
+			"alternative format for artist listing")
+		descending = flag.Bool("r", false, "reverse the sort order")
+		maxResults = flag.Int("n", 0, "list at most this many artists")
+	)
+	flag.Parse()
+	tpl, err := template.New("artist").Parse(*formatStr)
+	if err != nil {
+		return err
+	}
+
+	client, err := loadClient()
+	if err != nil {
+		return err
+	}
+	artists, err := client.ListArtists(*descending, *maxResults)
+	if err != nil {
+		return err
+	}
+	for _, artist := range artists {
+		if err := tpl.Execute(os.Stdout, artist); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func genres() error {
+	var (
+		formatStr = flag.String("f", "{{.TotalTrackCount}}\t{{.Name}}\n",
+			"alternative format for genre listing")
+		descending = flag.Bool("r", false, "reverse the sort order")
+		maxResults = flag.Int("n", 0, "list at most this many genres")
+	)
+	flag.Parse()
+	tpl, err := template.New("genre").Parse(*formatStr)
+	if err != nil {
+		return err
+	}
+
+	client, err := loadClient()
+	if err != nil {
+		return err
+	}
+	genres, err := client.ListGenres(*descending, *maxResults)
+	if err != nil {
+		return err
+	}
+	for _, genre := range genres {
+		if err := tpl.Execute(os.Stdout, genre); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+/*
+
+Library statistics
+
+Usage:
+
+	gmusic stats [-f format]
+
+Stats prints track counts by type and availability status, and the
+track counts of the user's playlists, in the following format:
+
+	LOCAL_TRACK	1287
+	PURCHASED_TRACK	34
+
+	AVAILABLE	1298
+	UPLOAD_REQUESTED	20
+	UPLOAD_PERMANENTLY_FAILED	3
+
+	42	3e2b1c0d-9f8e-3a7b-8c6d-5e4f3a2b1c0d	Road trip
+	...
+
+Tracks that are UPLOAD_REQUESTED or FORCE_REUPLOAD are waiting for their
+audio to be uploaded; see gmusic jobs.  Tracks that are
+UPLOAD_PERMANENTLY_FAILED will never become available.
+
+The -f flag can be used to specify an alternative format for the
+output, using the syntax of text/template.  The struct passed to the
+template is:
+
+	type Stats struct {
+		TrackTypes           map[TrackType]int
+		AvailabilityStatuses map[AvailabilityStatus]int
+		Playlists            []*PlaylistStats
+	}
+
+	type PlaylistStats struct {
+		PlaylistId     string
+		Name           string
+		TrackCount     int64
+		LastTimePlayed int64
+	}
+
+Stats is experimental; see gmusic register.
+
+*/
+package main
+
+import (
+	"flag"
+	"os"
+	"text/template"
+)
+
+const statsFormat = `{{range $k, $v := .TrackTypes}}{{$k}}	{{$v}}
+{{end}}
+{{range $k, $v := .AvailabilityStatuses}}{{$k}}	{{$v}}
+{{end}}
+{{range .Playlists}}{{.TrackCount}}	{{.PlaylistId}}	{{.Name}}
+{{end}}`
+
+func init() {
+	cmds["stats"] = stats
+}
+
+func stats() error {
+	formatStr := flag.String("f", statsFormat,
+		"alternative format for statistics")
+	flag.Parse()
+	tpl, err := template.New("stats").Parse(*formatStr)
+	if err != nil {
+		return err
+	}
+
+	client, err := loadClient()
+	if err != nil {
+		return err
+	}
+	stats, err := client.Stats()
+	if err != nil {
+		return err
+	}
+	return tpl.Execute(os.Stdout, stats)
+}
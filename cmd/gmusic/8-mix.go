@@ -0,0 +1,133 @@
+/*
+
+Generating mixes
+
+Usage:
+
+	gmusic mix [-f format] [-m model] [-n count] [-name name] [seed...]
+
+Mix generates a playlist of tracks in the user's Google Play Music
+library similar to the given seeds and lists its entries in the
+following format:
+
+	6eaf9df8-8a2c-3845-a443-80a500e07cbd	桜花爛漫
+	448aa24d-18b7-38e0-a49a-3ab11db6f012	Hotel Nichifornia
+	...
+
+The ID of the generated playlist is written to standard error.  If no
+seeds are given, a newline-separated list is read from standard input.
+
+A seed is a type and a value separated by a colon.  The type is one of
+"track", "artist", "album" or "opaque"; the value is the server ID of a
+track, the name of an artist, the name of an album, or an opaque seed,
+respectively.  For example:
+
+	gmusic mix track:6eaf9df8-8a2c-3845-a443-80a500e07cbd "artist:The Pillows"
+
+The -f flag can be used to specify an alternative format for the list,
+using the syntax of text/template.  The default format is
+"{{.TrackId}}\t{{with .Track}}{{.Title}}{{end}}\n", which leaves the
+title empty if the server did not return the track's metadata.  The
+struct passed to the template is:
+
+	type PlaylistEntry struct {
+		Id                    string
+		ClientId              string
+		PlaylistId            string
+		TrackId               string
+		AbsolutePosition      int64
+		CreationTimestamp     int64
+		LastModifiedTimestamp int64
+		Deleted               bool
+		Track                 *Track
+
+		// plus other, always-zero fields
+	}
+
+where Track is as in gmusic list, with the fields returned by the
+server populated, or nil.
+
+The -m flag names the recommendation model to use; the default is the
+server's choice.
+
+The -n flag sets the number of tracks in the mix; the default, 0, is the
+server's choice.
+
+The -name flag sets the name of the generated playlist.
+
+Mix is experimental; see gmusic register.
+
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/lxr/go.google.musicmanager"
+)
+
+var seedTypes = map[string]musicmanager.SeedType{
+	"track":  musicmanager.TrackSeed,
+	"artist": musicmanager.ArtistSeed,
+	"album":  musicmanager.AlbumSeed,
+	"opaque": musicmanager.OpaqueSeed,
+}
+
+func init() {
+	cmds["mix"] = mix
+}
+
+func mix() error {
+	var (
+		formatStr = flag.String("f", "{{.TrackId}}\t{{with .Track}}{{.Title}}{{end}}\n",
+			"alternative format for entry listing")
+		model = flag.String("m", "", "recommendation model to use")
+		count = flag.Int("n", 0, "number of tracks in the mix")
+		name  = flag.String("name", "", "name of the generated playlist")
+	)
+	flag.Parse()
+	// Leave only the seeds for getScanner.
+	os.Args = append(os.Args[:1], flag.Args()...)
+	tpl, err := template.New("entry").Parse(*formatStr)
+	if err != nil {
+		return err
+	}
+	req := &musicmanager.MixRequest{
+		Name:               *name,
+		NumRecommendations: *count,
+		ModelName:          *model,
+		IncludeTracks:      true,
+	}
+	sc := getScanner()
+	for sc.Scan() {
+		f := strings.SplitN(sc.Text(), ":", 2)
+		seedType, ok := seedTypes[f[0]]
+		if len(f) != 2 || !ok {
+			return fmt.Errorf("invalid seed %q", sc.Text())
+		}
+		req.Seeds = append(req.Seeds, musicmanager.Seed{Type: seedType, Value: f[1]})
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+
+	client, err := loadClient()
+	if err != nil {
+		return err
+	}
+	playlist, entries, err := client.GenerateMix(req)
+	if err != nil {
+		return err
+	}
+	logf("generated playlist %s\n", playlist.Id)
+	for _, entry := range entries {
+		if err := tpl.Execute(os.Stdout, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
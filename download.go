@@ -0,0 +1,194 @@
+// This file implements the resumable download of track audio from the
+// URLs returned by ExportTrack.
+
+package musicmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxDownloadAttempts is the number of consecutive attempts at
+// downloading a track that make no progress after which Download gives
+// up.
+const maxDownloadAttempts = 5
+
+// Download downloads the audio data of the track with the given server
+// ID into w.  Offset is the number of bytes of the track already in w
+// from an earlier download, from which the download is resumed.  If
+// offset is past the end of the track, the track is downloaded again
+// from the beginning.  Download returns the filename suggested by the
+// server for the track and the number of bytes of it in w, which is
+// less than offset if w has to be truncated.  Download only succeeds if
+// the number matches the size of the track reported by the server; if
+// the server does not report the size, no check is made, and Download
+// succeeds once the server has sent all it is going to send.
+//
+// If the download is interrupted by a network or server error,
+// Download resumes it from where it stopped, acquiring a new download
+// URL if the previous one has expired.  It gives up after several
+// consecutive attempts that make no progress, waiting for longer after
+// each one.  The returned count can then be given as the offset to a
+// later call to continue the download.
+func (c *Client) Download(id string, w io.WriterAt, offset int64) (name string, n int64, err error) {
+	return c.DownloadContext(context.Background(), id, w, offset)
+}
+
+// DownloadContext is like Download but takes a context.
+func (c *Client) DownloadContext(ctx context.Context, id string, w io.WriterAt, offset int64) (name string, n int64, err error) {
+	u, err := c.ExportTrackContext(ctx, id)
+	if err != nil {
+		return "", offset, err
+	}
+	size := int64(-1)
+	failures := 0
+	restarted := false
+	for {
+		start, m, total, fname, err := c.getAudio(ctx, u, w, offset)
+		if fname != "" {
+			name = fname
+		}
+		if total >= 0 {
+			size = total
+		}
+		progressed := m > 0 || start != offset
+		offset = start + m
+		if err == nil {
+			switch {
+			case size < 0 || offset == size:
+				return name, offset, nil
+			case offset > size && !restarted:
+				// w holds more than the whole track, so
+				// what is there cannot be trusted.
+				// Download it all over again.
+				restarted = true
+				offset = 0
+				continue
+			case offset > size:
+				return name, offset, fmt.Errorf("downloaded %d bytes of a %d-byte track", offset, size)
+			}
+			err = io.ErrUnexpectedEOF
+		}
+		if isExpired(err) {
+			// There is no point in waiting before retrying
+			// with a fresh URL.
+			if !progressed {
+				if failures++; failures >= maxDownloadAttempts {
+					return name, offset, err
+				}
+			}
+			if u, err = c.ExportTrackContext(ctx, id); err != nil {
+				return name, offset, err
+			}
+			continue
+		}
+		if !isTransient(ctx, err) {
+			return name, offset, err
+		}
+		if progressed {
+			failures = 0
+		} else if failures++; failures >= maxDownloadAttempts {
+			return name, offset, err
+		} else {
+			t := time.NewTimer(time.Duration(1<<uint(failures-1)) * time.Second)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return name, offset, ctx.Err()
+			case <-t.C:
+			}
+		}
+	}
+}
+
+// getAudio requests the audio data at the given URL from offset
+// onwards and copies it into w.  It returns the offset at which the
+// server started sending data, which is 0 if it ignored the range
+// request, the number of bytes copied, the total size of the track, or
+// -1 if the server did not say, and the filename suggested by the
+// server.
+func (c *Client) getAudio(ctx context.Context, u string, w io.WriterAt, offset int64) (start, n, size int64, name string, err error) {
+	size = -1
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return offset, 0, size, "", err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return offset, 0, size, "", err
+	}
+	defer resp.Body.Close()
+	name, _ = getName(resp.Header.Get("Content-Disposition"))
+	switch resp.StatusCode {
+	case http.StatusOK:
+		start, size = 0, resp.ContentLength
+	case http.StatusPartialContent:
+		var last int64
+		_, err = fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/%d", &start, &last, &size)
+		if err == nil && start != offset {
+			err = fmt.Errorf("server sent bytes from %d instead of %d", start, offset)
+		}
+		if err != nil {
+			return offset, 0, -1, name, err
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The offset is at or past the end of the track, so
+		// there is nothing left to download.
+		_, err = fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes */%d", &size)
+		return offset, 0, size, name, err
+	default:
+		buf, _ := ioutil.ReadAll(resp.Body)
+		return offset, 0, size, name, &textproto.Error{
+			Code: resp.StatusCode,
+			Msg:  string(buf),
+		}
+	}
+	n, err = io.Copy(&offsetWriter{w, start}, resp.Body)
+	return start, n, size, name, err
+}
+
+// isExpired reports whether err indicates that a download URL is no
+// longer valid.
+func isExpired(err error) bool {
+	e, ok := err.(*textproto.Error)
+	return ok && (e.Code == http.StatusUnauthorized ||
+		e.Code == http.StatusForbidden ||
+		e.Code == http.StatusNotFound ||
+		e.Code == http.StatusGone)
+}
+
+// getName extracts a UTF-8-encoded filename from a Content-Disposition
+// header.  (The default mime.ParseMediaType function cannot be used,
+// because it seems to disagree with the server on how to encode/decode
+// parens.)
+func getName(v string) (string, error) {
+	parts := strings.SplitN(v, "filename*=UTF-8''", 2)
+	if len(parts) < 2 {
+		return "", errors.New("media type value lacks UTF-8 filename")
+	}
+	return url.QueryUnescape(parts[1])
+}
+
+// An offsetWriter writes sequentially to an io.WriterAt from a given
+// offset.
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.w.WriteAt(p, w.off)
+	w.off += int64(n)
+	return n, err
+}
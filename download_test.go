@@ -0,0 +1,113 @@
+package musicmanager_test
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/lxr/go.google.musicmanager"
+	"github.com/lxr/go.google.musicmanager/musicmanagertest"
+)
+
+// A buffer is an in-memory io.WriterAt.
+type buffer struct {
+	b []byte
+}
+
+func (w *buffer) WriteAt(p []byte, off int64) (int, error) {
+	if n := int(off) + len(p); n > len(w.b) {
+		w.b = append(w.b, make([]byte, n-len(w.b))...)
+	}
+	copy(w.b[off:], p)
+	return len(p), nil
+}
+
+func TestDownload(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	audio := bytes.Repeat([]byte("abcdefghij"), 5000)
+	size := int64(len(audio))
+	id := s.AddTrack(&musicmanager.Track{Title: "song"}, audio)
+	c := newClient(t, s)
+
+	w := new(buffer)
+	name, n, err := c.Download(id, w, 0)
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if name != "song.mp3" || n != size || !bytes.Equal(w.b, audio) {
+		t.Errorf("Download: got %d bytes named %q, want %d named %q", n, name, size, "song.mp3")
+	}
+
+	_, n, err = c.Download(id, w, size)
+	if err != nil || n != size {
+		t.Errorf("Download of a complete track: got %d, %v, want %d", n, err, size)
+	}
+	if _, _, err := c.Download("no such track", new(buffer), 0); err == nil {
+		t.Error("Download of a missing track succeeded")
+	}
+}
+
+func TestDownloadURLExpiry(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	s.DownloadURLUses = 1
+	id := s.AddTrack(&musicmanager.Track{Title: "song"}, []byte("audio"))
+	c := newClient(t, s)
+	u, err := c.ExportTrack(id)
+	if err != nil {
+		t.Fatalf("ExportTrack: %v", err)
+	}
+	for i, want := range []int{http.StatusOK, http.StatusForbidden, http.StatusForbidden} {
+		resp, err := http.Get(u)
+		if err != nil {
+			t.Fatalf("GET %s: %v", u, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("request %d: got status %d, want %d", i, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	audio := bytes.Repeat([]byte("abcdefghij"), 5000)
+	size := int64(len(audio))
+	id := s.AddTrack(&musicmanager.Track{Title: "song"}, audio)
+	c := newClient(t, s)
+
+	// Drop the connection every 7000 bytes and expire the download
+	// URL after every two requests, so that the download is
+	// resumed several times with fresh URLs.
+	s.DownloadChunkLimit = 7000
+	s.DownloadURLUses = 2
+	w := &buffer{append([]byte(nil), audio[:1234]...)}
+	_, n, err := c.Download(id, w, 1234)
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if n != size || !bytes.Equal(w.b, audio) {
+		t.Errorf("Download: got %d bytes, want %d", n, size)
+	}
+}
+
+func TestDownloadOverlong(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	audio := []byte("the whole track")
+	id := s.AddTrack(&musicmanager.Track{Title: "song"}, audio)
+	c := newClient(t, s)
+
+	// The writer holds more than the track, as if left over from
+	// something else, so the download starts over.
+	w := &buffer{bytes.Repeat([]byte("x"), 100)}
+	_, n, err := c.Download(id, w, 100)
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if n != int64(len(audio)) || !bytes.Equal(w.b[:n], audio) {
+		t.Errorf("Download: got %q, want %q", w.b[:n], audio)
+	}
+}
@@ -5,82 +5,119 @@ package musicmanager
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/textproto"
 	"net/url"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 
 	mmdspb "github.com/lxr/go.google.musicmanager/internal/download_proto/service"
+	mmlspb "github.com/lxr/go.google.musicmanager/internal/locker_proto/service"
 	mmssjs "github.com/lxr/go.google.musicmanager/internal/session_json"
+	mmudpb "github.com/lxr/go.google.musicmanager/internal/upload_proto/data"
 	mmuspb "github.com/lxr/go.google.musicmanager/internal/upload_proto/service"
 )
 
-func (c *Client) upAuth(req *mmuspb.UpAuthRequest) (mmuspb.UploadResponse_AuthStatus, error) {
-	res, err := c.uploadServiceCall("upauth", req)
+func (c *Client) upAuth(ctx context.Context, req *mmuspb.UpAuthRequest) (mmuspb.UploadResponse_AuthStatus, error) {
+	res, err := c.uploadServiceCall(ctx, "upauth", req)
 	if err != nil {
 		return mmuspb.UploadResponse_UNKNOWN, err
 	}
 	return res.AuthStatus, nil
 }
 
-func (c *Client) uploadMetadata(req *mmuspb.UploadMetadataRequest) (*mmuspb.UploadMetadataResponse, error) {
-	res, err := c.uploadServiceCall("metadata?version=1", req)
+func (c *Client) uploadMetadata(ctx context.Context, req *mmuspb.UploadMetadataRequest) (*mmuspb.UploadMetadataResponse, error) {
+	res, err := c.uploadServiceCall(ctx, "metadata?version=1", req)
 	if err != nil {
 		return nil, err
 	}
+	if err := uploadBackoff(res.Policy); err != nil {
+		return nil, err
+	}
 	return res.MetadataResponse, nil
 }
 
-func (c *Client) uploadSample(req *mmuspb.UploadSampleRequest) (*mmuspb.UploadSampleResponse, error) {
-	res, err := c.uploadServiceCall("sample?version=1", req)
+func (c *Client) uploadSample(ctx context.Context, req *mmuspb.UploadSampleRequest) (*mmuspb.UploadSampleResponse, error) {
+	res, err := c.uploadServiceCall(ctx, "sample?version=1", req)
 	if err != nil {
 		return nil, err
 	}
+	if err := uploadBackoff(res.Policy); err != nil {
+		return nil, err
+	}
 	return res.SampleResponse, nil
 }
 
-func (c *Client) clientState(req *mmuspb.ClientStateRequest) (*mmuspb.ClientStateResponse, error) {
-	res, err := c.uploadServiceCall("clientstate", req)
+func (c *Client) uploadPlaylists(ctx context.Context, req *mmuspb.UploadPlaylistRequest) (*mmuspb.UploadPlaylistResponse, error) {
+	res, err := c.uploadServiceCall(ctx, "playlist?version=1", req)
+	if err != nil {
+		return nil, err
+	}
+	if err := uploadBackoff(res.Policy); err != nil {
+		return nil, err
+	}
+	if res.PlaylistResponse == nil {
+		return new(mmuspb.UploadPlaylistResponse), nil
+	}
+	return res.PlaylistResponse, nil
+}
+
+func (c *Client) uploadPlaylistEntries(ctx context.Context, req *mmuspb.UploadPlaylistEntryRequest) (*mmuspb.UploadPlaylistEntryResponse, error) {
+	res, err := c.uploadServiceCall(ctx, "playlistentry?version=1", req)
+	if err != nil {
+		return nil, err
+	}
+	if err := uploadBackoff(res.Policy); err != nil {
+		return nil, err
+	}
+	if res.PlaylistEntryResponse == nil {
+		return new(mmuspb.UploadPlaylistEntryResponse), nil
+	}
+	return res.PlaylistEntryResponse, nil
+}
+
+func (c *Client) clientState(ctx context.Context, req *mmuspb.ClientStateRequest) (*mmuspb.ClientStateResponse, error) {
+	res, err := c.uploadServiceCall(ctx, "clientstate", req)
 	if err != nil {
 		return nil, err
 	}
 	return res.ClientstateResponse, nil
 }
 
-func (c *Client) getJobs(req *mmuspb.GetJobsRequest) (*mmuspb.GetJobsResponse, error) {
-	res, err := c.uploadServiceCall("getjobs", req)
+func (c *Client) getJobs(ctx context.Context, req *mmuspb.GetJobsRequest) (*mmuspb.GetJobsResponse, error) {
+	res, err := c.uploadServiceCall(ctx, "getjobs", req)
 	if err != nil {
 		return nil, err
 	}
 	return res.GetjobsResponse, nil
 }
 
-func (c *Client) updateUploadState(req *mmuspb.UpdateUploadStateRequest) error {
-	_, err := c.uploadServiceCall("uploadstate", req)
+func (c *Client) updateUploadState(ctx context.Context, req *mmuspb.UpdateUploadStateRequest) error {
+	_, err := c.uploadServiceCall(ctx, "uploadstate", req)
 	return err
 }
 
-func (c *Client) deleteUploadRequested(req *mmuspb.DeleteUploadRequestedRequest) error {
-	_, err := c.uploadServiceCall("deleteuploadrequested", req)
+func (c *Client) deleteUploadRequested(ctx context.Context, req *mmuspb.DeleteUploadRequestedRequest) error {
+	_, err := c.uploadServiceCall(ctx, "deleteuploadrequested", req)
 	return err
 }
 
-func (c *Client) getTracksToExport(req *mmdspb.GetTracksToExportRequest) (*mmdspb.GetTracksToExportResponse, error) {
+func (c *Client) getTracksToExport(ctx context.Context, req *mmdspb.GetTracksToExportRequest) (*mmdspb.GetTracksToExportResponse, error) {
 	res := new(mmdspb.GetTracksToExportResponse)
-	url := "https://music.google.com/music/exportids"
-	return res, c.post(url, req, res)
+	return res, c.post(ctx, c.Endpoints.ExportIDs, req, res)
 }
 
-func (c *Client) getDownloadSession(req *mmssjs.GetDownloadSessionRequest) (*mmssjs.GetDownloadSessionResponse, error) {
-	urlStr := "https://music.google.com/music/export?" + url.Values{
+func (c *Client) getDownloadSession(ctx context.Context, req *mmssjs.GetDownloadSessionRequest) (*mmssjs.GetDownloadSessionResponse, error) {
+	urlStr := c.Endpoints.Export + "?" + url.Values{
 		"version": {"2"},
 		"songid":  {req.SongID},
 	}.Encode()
-	reqp, err := http.NewRequest("GET", urlStr, nil)
+	reqp, err := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -89,25 +126,127 @@ func (c *Client) getDownloadSession(req *mmssjs.GetDownloadSessionRequest) (*mms
 	return res, c.do(reqp, res)
 }
 
-func (c *Client) getUploadSession(req *mmssjs.GetUploadSessionRequest) (*mmssjs.GetUploadSessionResponse, error) {
+func (c *Client) getUploadSession(ctx context.Context, req *mmssjs.GetUploadSessionRequest) (*mmssjs.GetUploadSessionResponse, error) {
 	res := new(mmssjs.GetUploadSessionResponse)
-	url := "https://uploadsj.clients.google.com/uploadsj/scottyagent"
-	return res, c.post(url, req, res)
+	return res, c.post(ctx, c.Endpoints.UploadSession, req, res)
+}
+
+func (c *Client) getTracks(ctx context.Context, req *mmlspb.GetTracksRequest) (*mmlspb.GetTracksResponse, error) {
+	res := new(mmlspb.GetTracksResponse)
+	return res, c.lockerServiceCall(ctx, "gettracks", req, res)
+}
+
+func (c *Client) getPlaylists(ctx context.Context, req *mmlspb.GetPlaylistsRequest) (*mmlspb.GetPlaylistsResponse, error) {
+	res := new(mmlspb.GetPlaylistsResponse)
+	return res, c.lockerServiceCall(ctx, "getplaylists", req, res)
+}
+
+func (c *Client) getPlaylistEntries(ctx context.Context, req *mmlspb.GetPlaylistEntriesRequest) (*mmlspb.GetPlaylistEntriesResponse, error) {
+	res := new(mmlspb.GetPlaylistEntriesResponse)
+	return res, c.lockerServiceCall(ctx, "getplaylistentries", req, res)
+}
+
+func (c *Client) batchMutateTracks(ctx context.Context, req *mmlspb.BatchMutateTracksRequest) (*mmlspb.BatchMutateTracksResponse, error) {
+	res := new(mmlspb.BatchMutateTracksResponse)
+	return res, c.lockerServiceCall(ctx, "batchmutatetracks", req, res)
+}
+
+func (c *Client) batchMutatePlaylists(ctx context.Context, req *mmlspb.BatchMutatePlaylistsRequest) (*mmlspb.BatchMutatePlaylistsResponse, error) {
+	res := new(mmlspb.BatchMutatePlaylistsResponse)
+	return res, c.lockerServiceCall(ctx, "batchmutateplaylists", req, res)
+}
+
+func (c *Client) batchMutatePlaylistEntries(ctx context.Context, req *mmlspb.BatchMutatePlaylistEntriesRequest) (*mmlspb.BatchMutatePlaylistEntriesResponse, error) {
+	res := new(mmlspb.BatchMutatePlaylistEntriesResponse)
+	return res, c.lockerServiceCall(ctx, "batchmutateplaylistentries", req, res)
+}
+
+func (c *Client) getAlbums(ctx context.Context, req *mmlspb.GetAlbumsRequest) (*mmlspb.GetAlbumsResponse, error) {
+	res := new(mmlspb.GetAlbumsResponse)
+	return res, c.lockerServiceCall(ctx, "getalbums", req, res)
+}
+
+func (c *Client) getArtists(ctx context.Context, req *mmlspb.GetArtistsRequest) (*mmlspb.GetArtistsResponse, error) {
+	res := new(mmlspb.GetArtistsResponse)
+	return res, c.lockerServiceCall(ctx, "getartists", req, res)
+}
+
+func (c *Client) getGenres(ctx context.Context, req *mmlspb.GetGenresRequest) (*mmlspb.GetGenresResponse, error) {
+	res := new(mmlspb.GetGenresResponse)
+	return res, c.lockerServiceCall(ctx, "getgenres", req, res)
+}
+
+func (c *Client) getAggregationsByTrackType(ctx context.Context, req *mmlspb.GetAggregationsByTrackTypeRequest) (*mmlspb.GetAggregationsByTrackTypeResponse, error) {
+	res := new(mmlspb.GetAggregationsByTrackTypeResponse)
+	return res, c.lockerServiceCall(ctx, "getaggregationsbytracktype", req, res)
+}
+
+func (c *Client) getAggregationsByAvailabilityStatus(ctx context.Context, req *mmlspb.GetAggregationsByAvailabilityStatusRequest) (*mmlspb.GetAggregationsByAvailabilityStatusResponse, error) {
+	res := new(mmlspb.GetAggregationsByAvailabilityStatusResponse)
+	return res, c.lockerServiceCall(ctx, "getaggregationsbyavailabilitystatus", req, res)
+}
+
+func (c *Client) getPlaylistAggregations(ctx context.Context, req *mmlspb.GetPlaylistAggregationsRequest) (*mmlspb.GetPlaylistAggregationsResponse, error) {
+	res := new(mmlspb.GetPlaylistAggregationsResponse)
+	return res, c.lockerServiceCall(ctx, "getplaylistaggregations", req, res)
+}
+
+func (c *Client) getDynamicPlaylistEntries(ctx context.Context, req *mmlspb.GetDynamicPlaylistEntriesRequest) (*mmlspb.GetDynamicPlaylistEntriesResponse, error) {
+	res := new(mmlspb.GetDynamicPlaylistEntriesResponse)
+	return res, c.lockerServiceCall(ctx, "getdynamicplaylistentries", req, res)
+}
+
+func (c *Client) magicPlaylist(ctx context.Context, req *mmlspb.MagicPlaylistRequest) (*mmlspb.MagicPlaylistResponse, error) {
+	res := new(mmlspb.MagicPlaylistResponse)
+	return res, c.lockerServiceCall(ctx, "magicplaylist", req, res)
+}
+
+func (c *Client) batchLookup(ctx context.Context, req *mmlspb.BatchLookupRequest) (*mmlspb.BatchLookupResponse, error) {
+	res := new(mmlspb.BatchLookupResponse)
+	return res, c.lockerServiceCall(ctx, "batchlookup", req, res)
+}
+
+// lockerServiceCall protobuf-encodes the request and POSTs it to the
+// named endpoint under c.Endpoints.Locker, decoding the response into
+// res.
+func (c *Client) lockerServiceCall(ctx context.Context, endpoint string, req, res proto.Message) error {
+	if c.Endpoints.Locker == "" {
+		return ErrNoLocker
+	}
+	return c.post(ctx, c.Endpoints.Locker+endpoint, req, res)
 }
 
 // uploadServiceCall protobuf-encodes the request and POSTs it to the
-// named endpoint under https://android.clients.google.com/upsj/,
-// decoding the response as a *pb.UploadResponse.
-func (c *Client) uploadServiceCall(endpoint string, req interface{}) (*mmuspb.UploadResponse, error) {
-	const baseURL = "https://android.clients.google.com/upsj/"
+// named endpoint under c.Endpoints.UploadService, decoding the response
+// as a *pb.UploadResponse.  The client policy contained in the
+// response, if any, is recorded in c.
+func (c *Client) uploadServiceCall(ctx context.Context, endpoint string, req interface{}) (*mmuspb.UploadResponse, error) {
 	res := new(mmuspb.UploadResponse)
-	return res, c.post(baseURL+endpoint, req, res)
+	if err := c.post(ctx, c.Endpoints.UploadService+endpoint, req, res); err != nil {
+		return res, err
+	}
+	if res.Policy != nil {
+		c.setPolicy(res.Policy)
+	}
+	return res, nil
+}
+
+// uploadBackoff returns a *BackoffError if the given client policy asks
+// the client to pause or abort its uploads, and nil otherwise.
+func uploadBackoff(p *mmudpb.ClientPolicy) error {
+	if p == nil || !p.PauseUploads && !p.Abort {
+		return nil
+	}
+	return &BackoffError{
+		Abort:   p.Abort,
+		RetryIn: time.Duration(p.RetryInMinutes) * time.Minute,
+	}
 }
 
 // post encodes the request object as protobuf if it implements
 // proto.Message, and as JSON otherwise, and POSTs the result to the
-// given URL.  The response is then similarly decoded.
-func (c *Client) post(url string, req, res interface{}) error {
+// given URL under ctx.  The response is then similarly decoded.
+func (c *Client) post(ctx context.Context, url string, req, res interface{}) error {
 	var body io.Reader
 	var buf []byte
 	var err error
@@ -123,7 +262,7 @@ func (c *Client) post(url string, req, res interface{}) error {
 	if buf != nil {
 		body = bytes.NewReader(buf)
 	}
-	reqp, err := http.NewRequest("POST", url, body)
+	reqp, err := http.NewRequestWithContext(ctx, "POST", url, body)
 	if err != nil {
 		return err
 	}
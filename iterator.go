@@ -0,0 +1,122 @@
+// This file implements iteration over paginated listings.
+
+package musicmanager
+
+import (
+	"context"
+	"fmt"
+)
+
+// A PageError records the page of a paginated listing on which an
+// error occurred.
+type PageError struct {
+	// The number of the page, counting from 0.
+	Page int
+
+	// The page token with which the page was requested.
+	PageToken string
+
+	// The error that occurred, typically a ListError.
+	Err error
+}
+
+func (e *PageError) Error() string {
+	return fmt.Sprintf("musicmanager: page %d: %v", e.Page, e.Err)
+}
+
+func (e *PageError) Unwrap() error {
+	return e.Err
+}
+
+// A TrackIterator iterates over the pages of a track listing,
+// following page tokens transparently.  Successive calls to Next
+// advance the iterator to the next page, which is then available
+// through TrackList.  Iteration stops at the last page or at the first
+// error.  The caller may also stop iterating at any time simply by not
+// calling Next anymore.
+//
+//	it := client.Tracks(false, 0)
+//	for it.Next() {
+//		for _, track := range it.TrackList().Items {
+//			...
+//		}
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//	cursor := it.UpdatedMin()
+type TrackIterator struct {
+	c             *Client
+	ctx           context.Context
+	purchasedOnly bool
+	updatedMin    int64
+	maxUpdatedMin int64
+	pageToken     string
+	page          int
+	list          *TrackList
+	done          bool
+	err           error
+}
+
+// Tracks returns an iterator over all pages of the track listing that
+// ListTracks would return for the given arguments.
+func (c *Client) Tracks(purchasedOnly bool, updatedMin int64) *TrackIterator {
+	return c.TracksContext(context.Background(), purchasedOnly, updatedMin)
+}
+
+// TracksContext is like Tracks but takes a context.
+func (c *Client) TracksContext(ctx context.Context, purchasedOnly bool, updatedMin int64) *TrackIterator {
+	return &TrackIterator{
+		c:             c,
+		ctx:           ctx,
+		purchasedOnly: purchasedOnly,
+		updatedMin:    updatedMin,
+		maxUpdatedMin: updatedMin,
+	}
+}
+
+// Next fetches the next page of the listing.  It returns false when
+// there are no more pages or an error occurred.
+func (it *TrackIterator) Next() bool {
+	if it.done {
+		return false
+	}
+	list, err := it.c.ListTracksContext(it.ctx, it.purchasedOnly, it.updatedMin, it.pageToken)
+	if err != nil {
+		it.err = &PageError{
+			Page:      it.page,
+			PageToken: it.pageToken,
+			Err:       err,
+		}
+		it.list = nil
+		it.done = true
+		return false
+	}
+	if list.UpdatedMin > it.maxUpdatedMin {
+		it.maxUpdatedMin = list.UpdatedMin
+	}
+	it.list = list
+	it.page++
+	it.pageToken = list.PageToken
+	it.done = list.PageToken == ""
+	return true
+}
+
+// TrackList returns the page fetched by the most recent call to Next.
+func (it *TrackIterator) TrackList() *TrackList {
+	return it.list
+}
+
+// Err returns the error that stopped the iteration, if any.  The error
+// is of type *PageError.
+func (it *TrackIterator) Err() error {
+	return it.err
+}
+
+// UpdatedMin returns the greatest modification timestamp seen so far in
+// the listing, or the timestamp the listing was started with if it is
+// greater.  Once the iteration has completed without error, passing
+// this value to a new listing returns only the tracks modified since.
+func (it *TrackIterator) UpdatedMin() int64 {
+	return it.maxUpdatedMin
+}
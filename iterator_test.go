@@ -0,0 +1,52 @@
+package musicmanager_test
+
+import (
+	"testing"
+
+	"github.com/lxr/go.google.musicmanager"
+	"github.com/lxr/go.google.musicmanager/musicmanagertest"
+)
+
+// countTracks walks it to the end and returns the number of tracks and
+// pages it yielded.
+func countTracks(t *testing.T, it *musicmanager.TrackIterator) (n, pages int) {
+	t.Helper()
+	for it.Next() {
+		n += len(it.TrackList().Items)
+		pages++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("TrackIterator: %v", err)
+	}
+	return n, pages
+}
+
+func TestTrackIterator(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	s.PageSize = 2
+	for i := 0; i < 5; i++ {
+		s.AddTrack(&musicmanager.Track{Title: "t"}, nil)
+	}
+	c := newClient(t, s)
+	it := c.Tracks(false, 0)
+	if n, pages := countTracks(t, it); n != 5 || pages != 3 {
+		t.Errorf("iterated over %d tracks in %d pages, want 5 in 3", n, pages)
+	}
+	updatedMin := it.UpdatedMin()
+	it = c.Tracks(false, updatedMin)
+	if n, _ := countTracks(t, it); n != 0 {
+		t.Errorf("iterated over %d tracks updated since the last listing, want 0", n)
+	}
+	if it.UpdatedMin() != updatedMin {
+		t.Errorf("UpdatedMin changed from %d to %d without updates", updatedMin, it.UpdatedMin())
+	}
+	s.AddTrack(&musicmanager.Track{Title: "new"}, nil)
+	it = c.Tracks(false, updatedMin)
+	if n, _ := countTracks(t, it); n != 1 {
+		t.Errorf("iterated over %d new tracks, want 1", n)
+	}
+	if it.UpdatedMin() <= updatedMin {
+		t.Errorf("UpdatedMin did not advance past %d", updatedMin)
+	}
+}
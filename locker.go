@@ -0,0 +1,823 @@
+// This file implements the calls of the locker service, which manages
+// the metadata of a user's library.  They are experimental; see the
+// package documentation.
+
+package musicmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	convert "github.com/lxr/go.google.musicmanager/internal/convert"
+	mmldpb "github.com/lxr/go.google.musicmanager/internal/locker_proto/data"
+	mmlspb "github.com/lxr/go.google.musicmanager/internal/locker_proto/service"
+)
+
+// ErrNoLocker is returned by the experimental methods that use the
+// locker service if the client's Endpoints.Locker is empty, as it is
+// by default.
+var ErrNoLocker = errors.New("musicmanager: locker service endpoint not set")
+
+// QueryTracks lists the user's tracks with their full metadata,
+// filtered and sorted as described by q.  A nil q is equivalent to a
+// zero TrackQuery.  Long responses may be returned in chunks, in which
+// case the PageToken field of the TrackList object should be given to
+// a new QueryTracks call with the same query.  If no tracks have been
+// modified after q.UpdatedMin, QueryTracks returns an empty TrackList.
+func (c *Client) QueryTracks(q *TrackQuery, pageToken string) (*TrackList, error) {
+	return c.QueryTracksContext(context.Background(), q, pageToken)
+}
+
+// QueryTracksContext is like QueryTracks but takes a context.
+func (c *Client) QueryTracksContext(ctx context.Context, q *TrackQuery, pageToken string) (*TrackList, error) {
+	if q == nil {
+		q = new(TrackQuery)
+	}
+	req := &mmlspb.GetTracksRequest{
+		UpdatedMin:        q.UpdatedMin,
+		IncludeDeleted:    q.IncludeDeleted,
+		MaxResults:        int32(q.MaxResults),
+		ContinuationToken: pageToken,
+		SearchRestriction: restrictionsToProto(q.Restrictions),
+		TrackProjection:   mmlspb.GetTracksRequest_TrackProjection(q.Projection),
+	}
+	if q.RestrictionSet != nil {
+		req.RestrictionSet = restrictionSetToProto(q.RestrictionSet)
+	}
+	for _, o := range q.SortOrder {
+		req.SortOrder = append(req.SortOrder, &mmldpb.TrackSortOrder{
+			Attribute:  mmldpb.TrackSortOrder_TrackAttribute(o.Attribute),
+			Descending: o.Descending,
+		})
+	}
+	res, err := c.getTracks(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	trackList := new(TrackList)
+	switch res.ResponseCode {
+	case mmlspb.GetTracksResponse_OK:
+	case mmlspb.GetTracksResponse_NOT_MODIFIED:
+		return trackList, nil
+	default:
+		return nil, LockerError(res.ResponseCode)
+	}
+	trackList.Items = tracksFromProto(res.Track)
+	trackList.PageToken = res.ContinuationToken
+	for _, t := range trackList.Items {
+		if t.LastModifiedTimestamp > trackList.UpdatedMin {
+			trackList.UpdatedMin = t.LastModifiedTimestamp
+		}
+	}
+	return trackList, nil
+}
+
+// tracksFromProto converts locker tracks to Tracks.
+func tracksFromProto(trks []*mmldpb.Track) []*Track {
+	tracks := make([]*Track, len(trks))
+	for i, trk := range trks {
+		tracks[i] = new(Track)
+		convert.Convert(tracks[i], trk)
+		tracks[i].TrackSize = trk.EstimatedSize
+		if len(trk.AlbumArtRef) > 0 {
+			tracks[i].AlbumArtUrl = trk.AlbumArtRef[0].Url
+		}
+	}
+	return tracks
+}
+
+func restrictionsToProto(rs []Restriction) []*mmldpb.TrackSearchRestriction {
+	if len(rs) == 0 {
+		return nil
+	}
+	pbs := make([]*mmldpb.TrackSearchRestriction, len(rs))
+	for i, r := range rs {
+		pbs[i] = &mmldpb.TrackSearchRestriction{
+			Attribute:      mmldpb.TrackSearchRestriction_TrackAttribute(r.Attribute),
+			Value:          r.Value,
+			ComparisonType: mmldpb.TrackSearchRestriction_ComparisonType(r.Comparison),
+		}
+	}
+	return pbs
+}
+
+func restrictionSetToProto(rs *RestrictionSet) *mmldpb.TrackSearchRestrictionSet {
+	pb := &mmldpb.TrackSearchRestrictionSet{
+		Restriction: restrictionsToProto(rs.Restrictions),
+	}
+	if rs.Or {
+		pb.Type = mmldpb.TrackSearchRestrictionSet_OR
+	}
+	for _, sub := range rs.SubSets {
+		pb.SubSet = append(pb.SubSet, restrictionSetToProto(sub))
+	}
+	return pb
+}
+
+// ListPlaylists returns a list of the user's playlists that have been
+// modified after the given Unix timestamp (microsecond precision).
+// Long responses may be returned in chunks, in which case the
+// PageToken field of the PlaylistList object should be given to a new
+// ListPlaylists call with the same updatedMin.
+func (c *Client) ListPlaylists(updatedMin int64, pageToken string) (*PlaylistList, error) {
+	return c.ListPlaylistsContext(context.Background(), updatedMin, pageToken)
+}
+
+// ListPlaylistsContext is like ListPlaylists but takes a context.
+func (c *Client) ListPlaylistsContext(ctx context.Context, updatedMin int64, pageToken string) (*PlaylistList, error) {
+	res, err := c.getPlaylists(ctx, &mmlspb.GetPlaylistsRequest{
+		UpdatedMin:        updatedMin,
+		ContinuationToken: pageToken,
+	})
+	if err != nil {
+		return nil, err
+	}
+	playlistList := new(PlaylistList)
+	switch res.ResponseCode {
+	case mmlspb.GetPlaylistsResponse_OK:
+	case mmlspb.GetPlaylistsResponse_NOT_MODIFIED:
+		return playlistList, nil
+	default:
+		return nil, LockerError(res.ResponseCode)
+	}
+	convert.Convert(playlistList, res)
+	for _, p := range playlistList.Items {
+		if p.LastModifiedTimestamp > playlistList.UpdatedMin {
+			playlistList.UpdatedMin = p.LastModifiedTimestamp
+		}
+	}
+	return playlistList, nil
+}
+
+// ListPlaylistEntries returns a list of the entries of the given
+// playlist that have been modified after the given Unix timestamp
+// (microsecond precision).  If playlistID is empty, the entries of all
+// playlists are listed.  The entries include the metadata of their
+// tracks.  Long responses may be returned in chunks, in which case the
+// PageToken field of the PlaylistEntryList object should be given to a
+// new ListPlaylistEntries call with the same playlistID and updatedMin.
+func (c *Client) ListPlaylistEntries(playlistID string, updatedMin int64, pageToken string) (*PlaylistEntryList, error) {
+	return c.ListPlaylistEntriesContext(context.Background(), playlistID, updatedMin, pageToken)
+}
+
+// ListPlaylistEntriesContext is like ListPlaylistEntries but takes a
+// context.
+func (c *Client) ListPlaylistEntriesContext(ctx context.Context, playlistID string, updatedMin int64, pageToken string) (*PlaylistEntryList, error) {
+	res, err := c.getPlaylistEntries(ctx, &mmlspb.GetPlaylistEntriesRequest{
+		UpdatedMin:              updatedMin,
+		ContinuationToken:       pageToken,
+		PlaylistIdFilter:        playlistID,
+		IncludeAllTrackMetadata: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	entryList := new(PlaylistEntryList)
+	switch res.ResponseCode {
+	case mmlspb.GetPlaylistEntriesResponse_OK:
+	case mmlspb.GetPlaylistEntriesResponse_NOT_MODIFIED:
+		return entryList, nil
+	default:
+		return nil, LockerError(res.ResponseCode)
+	}
+	entryList.fromProto(res.PlaylistEntry, res.ContinuationToken)
+	return entryList, nil
+}
+
+// ListDynamicPlaylistEntries returns the entries of the given
+// automatically generated playlist.  If includeTracks is true, the
+// entries include the metadata of their tracks.  Long responses may be
+// returned in chunks, in which case the PageToken field of the
+// PlaylistEntryList object should be given to a new
+// ListDynamicPlaylistEntries call with the same playlist.  The
+// UpdatedMin field of the returned list is informational only.  If the
+// server refuses to list the entries, the error is of type
+// DynamicPlaylistError.
+func (c *Client) ListDynamicPlaylistEntries(p DynamicPlaylist, includeTracks bool, pageToken string) (*PlaylistEntryList, error) {
+	return c.ListDynamicPlaylistEntriesContext(context.Background(), p, includeTracks, pageToken)
+}
+
+// ListDynamicPlaylistEntriesContext is like ListDynamicPlaylistEntries
+// but takes a context.
+func (c *Client) ListDynamicPlaylistEntriesContext(ctx context.Context, p DynamicPlaylist, includeTracks bool, pageToken string) (*PlaylistEntryList, error) {
+	res, err := c.getDynamicPlaylistEntries(ctx, &mmlspb.GetDynamicPlaylistEntriesRequest{
+		PlaylistEntriesType:     mmlspb.GetDynamicPlaylistEntriesRequest_DynamicPlaylistEntriesType(p),
+		ContinuationToken:       pageToken,
+		IncludeAllTrackMetadata: includeTracks,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res.ResponseCode != mmlspb.GetDynamicPlaylistEntriesResponse_OK {
+		return nil, DynamicPlaylistError(res.ResponseCode)
+	}
+	entryList := new(PlaylistEntryList)
+	entryList.fromProto(res.PlaylistEntry, res.ContinuationToken)
+	return entryList, nil
+}
+
+// fromProto fills l with the given playlist entries and page token.
+func (l *PlaylistEntryList) fromProto(ents []*mmldpb.PlaylistEntry, pageToken string) {
+	convert.Convert(&l.Items, ents)
+	l.PageToken = pageToken
+	for i, e := range l.Items {
+		if trk := ents[i].Track; trk != nil {
+			e.Track.TrackSize = trk.EstimatedSize
+		}
+		if e.LastModifiedTimestamp > l.UpdatedMin {
+			l.UpdatedMin = e.LastModifiedTimestamp
+		}
+	}
+}
+
+// UpdateTracks updates the metadata of the given tracks, identified by
+// their Id fields.  Only the non-zero fields of the tracks are
+// updated, so a field cannot be cleared; this keeps the fields that
+// the server maintains and Track does not carry, such as the reference
+// to a track's audio, from being wiped.  The returned slice holds an
+// error for each track, which is nil if the update succeeded and
+// otherwise typically of type MutateError.
+func (c *Client) UpdateTracks(tracks []*Track) []error {
+	return c.UpdateTracksContext(context.Background(), tracks)
+}
+
+// UpdateTracksContext is like UpdateTracks but takes a context.
+func (c *Client) UpdateTracksContext(ctx context.Context, tracks []*Track) []error {
+	reqs := make([]*mmlspb.MutateTrackRequest, len(tracks))
+	for i, t := range tracks {
+		trk := new(mmldpb.Track)
+		convert.Convert(trk, t)
+		reqs[i] = &mmlspb.MutateTrackRequest{
+			UpdateTrack:        trk,
+			PartialUpdate:      true,
+			UpdateLastModified: true,
+		}
+	}
+	return c.mutateTracks(ctx, reqs)
+}
+
+// DeleteTracks deletes the tracks with the given server IDs.  Deleted
+// tracks can be restored with UndeleteTracks.  Errors are reported as
+// with UpdateTracks.
+func (c *Client) DeleteTracks(ids []string) []error {
+	return c.DeleteTracksContext(context.Background(), ids)
+}
+
+// DeleteTracksContext is like DeleteTracks but takes a context.
+func (c *Client) DeleteTracksContext(ctx context.Context, ids []string) []error {
+	reqs := make([]*mmlspb.MutateTrackRequest, len(ids))
+	for i, id := range ids {
+		reqs[i] = &mmlspb.MutateTrackRequest{
+			DeleteTrack:        id,
+			UpdateLastModified: true,
+		}
+	}
+	return c.mutateTracks(ctx, reqs)
+}
+
+// UndeleteTracks restores the deleted tracks with the given server IDs.
+// Errors are reported as with UpdateTracks.
+func (c *Client) UndeleteTracks(ids []string) []error {
+	return c.UndeleteTracksContext(context.Background(), ids)
+}
+
+// UndeleteTracksContext is like UndeleteTracks but takes a context.
+func (c *Client) UndeleteTracksContext(ctx context.Context, ids []string) []error {
+	reqs := make([]*mmlspb.MutateTrackRequest, len(ids))
+	for i, id := range ids {
+		reqs[i] = &mmlspb.MutateTrackRequest{
+			UndeleteTrack:      id,
+			UpdateLastModified: true,
+		}
+	}
+	return c.mutateTracks(ctx, reqs)
+}
+
+// mutateTracks sends the given track mutations to the server in one
+// batch and returns an error for each of them.
+func (c *Client) mutateTracks(ctx context.Context, reqs []*mmlspb.MutateTrackRequest) []error {
+	if len(reqs) == 0 {
+		return []error{}
+	}
+	res, err := c.batchMutateTracks(ctx, &mmlspb.BatchMutateTracksRequest{
+		TrackMutation:           reqs,
+		DetectTimestampConflict: c.DetectConflicts,
+	})
+	_, errs := mutateResults(res.GetMutateResponse(), len(reqs), err)
+	return errs
+}
+
+// CreatePlaylists creates the given playlists and returns their server
+// IDs.  The Id fields of the playlists are ignored.  Errors are
+// reported as with UpdateTracks.
+func (c *Client) CreatePlaylists(playlists []*Playlist) (ids []string, errs []error) {
+	return c.CreatePlaylistsContext(context.Background(), playlists)
+}
+
+// CreatePlaylistsContext is like CreatePlaylists but takes a context.
+func (c *Client) CreatePlaylistsContext(ctx context.Context, playlists []*Playlist) (ids []string, errs []error) {
+	reqs := make([]*mmlspb.MutatePlaylistRequest, len(playlists))
+	for i, p := range playlists {
+		pl := new(mmldpb.Playlist)
+		convert.Convert(pl, p)
+		pl.Id = ""
+		reqs[i] = &mmlspb.MutatePlaylistRequest{CreatePlaylist: pl}
+	}
+	return c.mutatePlaylists(ctx, reqs)
+}
+
+// UpdatePlaylists replaces the metadata of the given playlists,
+// identified by their Id fields, with the given values.  If partial is
+// true, only the non-zero fields of the playlists are updated; for
+// example, a playlist can be renamed by giving only its Id and Name.
+// Errors are reported as with UpdateTracks.
+func (c *Client) UpdatePlaylists(playlists []*Playlist, partial bool) []error {
+	return c.UpdatePlaylistsContext(context.Background(), playlists, partial)
+}
+
+// UpdatePlaylistsContext is like UpdatePlaylists but takes a context.
+func (c *Client) UpdatePlaylistsContext(ctx context.Context, playlists []*Playlist, partial bool) []error {
+	reqs := make([]*mmlspb.MutatePlaylistRequest, len(playlists))
+	for i, p := range playlists {
+		pl := new(mmldpb.Playlist)
+		convert.Convert(pl, p)
+		reqs[i] = &mmlspb.MutatePlaylistRequest{
+			UpdatePlaylist:     pl,
+			PartialUpdate:      partial,
+			UpdateLastModified: true,
+		}
+	}
+	_, errs := c.mutatePlaylists(ctx, reqs)
+	return errs
+}
+
+// DeletePlaylists deletes the playlists with the given server IDs,
+// along with their entries.  Errors are reported as with UpdateTracks.
+func (c *Client) DeletePlaylists(ids []string) []error {
+	return c.DeletePlaylistsContext(context.Background(), ids)
+}
+
+// DeletePlaylistsContext is like DeletePlaylists but takes a context.
+func (c *Client) DeletePlaylistsContext(ctx context.Context, ids []string) []error {
+	reqs := make([]*mmlspb.MutatePlaylistRequest, len(ids))
+	for i, id := range ids {
+		reqs[i] = &mmlspb.MutatePlaylistRequest{
+			DeletePlaylist:     id,
+			UpdateLastModified: true,
+		}
+	}
+	_, errs := c.mutatePlaylists(ctx, reqs)
+	return errs
+}
+
+// CreatePlaylistEntries adds the tracks named by the TrackId fields of
+// the given entries to the playlists named by their PlaylistId fields,
+// and returns the server IDs of the new entries.  The entries are
+// placed as described by their PlaceAfterEntryId and
+// PlaceBeforeEntryId fields.  Errors are reported as with UpdateTracks.
+func (c *Client) CreatePlaylistEntries(entries []*PlaylistEntry) (ids []string, errs []error) {
+	return c.CreatePlaylistEntriesContext(context.Background(), entries)
+}
+
+// CreatePlaylistEntriesContext is like CreatePlaylistEntries but takes
+// a context.
+func (c *Client) CreatePlaylistEntriesContext(ctx context.Context, entries []*PlaylistEntry) (ids []string, errs []error) {
+	reqs := make([]*mmlspb.MutatePlaylistEntryRequest, len(entries))
+	for i, e := range entries {
+		ent := playlistEntryToProto(e)
+		ent.Id = ""
+		reqs[i] = &mmlspb.MutatePlaylistEntryRequest{CreatePlaylistEntry: ent}
+	}
+	return c.mutatePlaylistEntries(ctx, reqs)
+}
+
+// UpdatePlaylistEntries updates the given playlist entries, identified
+// by their Id fields.  An entry is moved within its playlist by setting
+// its PlaceAfterEntryId or PlaceBeforeEntryId field.  Errors are
+// reported as with UpdateTracks.
+func (c *Client) UpdatePlaylistEntries(entries []*PlaylistEntry) []error {
+	return c.UpdatePlaylistEntriesContext(context.Background(), entries)
+}
+
+// UpdatePlaylistEntriesContext is like UpdatePlaylistEntries but takes
+// a context.
+func (c *Client) UpdatePlaylistEntriesContext(ctx context.Context, entries []*PlaylistEntry) []error {
+	reqs := make([]*mmlspb.MutatePlaylistEntryRequest, len(entries))
+	for i, e := range entries {
+		reqs[i] = &mmlspb.MutatePlaylistEntryRequest{
+			UpdatePlaylistEntry: playlistEntryToProto(e),
+			UpdateLastModified:  true,
+		}
+	}
+	_, errs := c.mutatePlaylistEntries(ctx, reqs)
+	return errs
+}
+
+// DeletePlaylistEntries removes the given entries, identified by their
+// Id fields, from their playlists.  Errors are reported as with
+// UpdateTracks.
+func (c *Client) DeletePlaylistEntries(entries []*PlaylistEntry) []error {
+	return c.DeletePlaylistEntriesContext(context.Background(), entries)
+}
+
+// DeletePlaylistEntriesContext is like DeletePlaylistEntries but takes
+// a context.
+func (c *Client) DeletePlaylistEntriesContext(ctx context.Context, entries []*PlaylistEntry) []error {
+	reqs := make([]*mmlspb.MutatePlaylistEntryRequest, len(entries))
+	for i, e := range entries {
+		reqs[i] = &mmlspb.MutatePlaylistEntryRequest{
+			DeletePlaylistEntry: playlistEntryToProto(e),
+			UpdateLastModified:  true,
+		}
+	}
+	_, errs := c.mutatePlaylistEntries(ctx, reqs)
+	return errs
+}
+
+// playlistEntryToProto converts a PlaylistEntry to its protobuf form,
+// leaving out the track metadata.
+func playlistEntryToProto(e *PlaylistEntry) *mmldpb.PlaylistEntry {
+	ent := new(mmldpb.PlaylistEntry)
+	convert.Convert(ent, e)
+	ent.Track = nil
+	if ent.PlaceAfterEntryId != "" || ent.PlaceBeforeEntryId != "" {
+		ent.RelativePositionIdType = mmldpb.PlaylistEntry_SERVER
+	}
+	return ent
+}
+
+// mutatePlaylists sends the given playlist mutations to the server in
+// one batch and returns the resulting IDs and an error for each of
+// them.
+func (c *Client) mutatePlaylists(ctx context.Context, reqs []*mmlspb.MutatePlaylistRequest) ([]string, []error) {
+	if len(reqs) == 0 {
+		return []string{}, []error{}
+	}
+	res, err := c.batchMutatePlaylists(ctx, &mmlspb.BatchMutatePlaylistsRequest{
+		PlaylistMutation:        reqs,
+		DetectTimestampConflict: c.DetectConflicts,
+	})
+	return mutateResults(res.GetMutateResponse(), len(reqs), err)
+}
+
+// mutatePlaylistEntries is like mutatePlaylists but for playlist
+// entries.
+func (c *Client) mutatePlaylistEntries(ctx context.Context, reqs []*mmlspb.MutatePlaylistEntryRequest) ([]string, []error) {
+	if len(reqs) == 0 {
+		return []string{}, []error{}
+	}
+	res, err := c.batchMutatePlaylistEntries(ctx, &mmlspb.BatchMutatePlaylistEntriesRequest{
+		PlaylistEntryMutation:   reqs,
+		DetectTimestampConflict: c.DetectConflicts,
+	})
+	return mutateResults(res.GetMutateResponse(), len(reqs), err)
+}
+
+// mutateResults returns the IDs and errors of n mutations given the
+// responses to them and the error of the batch call.
+func mutateResults(res []*mmlspb.MutateResponse, n int, err error) ([]string, []error) {
+	ids := make([]string, n)
+	errs := make([]error, n)
+	for i := range errs {
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		if errs[i] = mutateError(res, i); errs[i] == nil {
+			ids[i] = res[i].Id
+		}
+	}
+	return ids, errs
+}
+
+// mutateError returns the error corresponding to the i'th of the given
+// mutate responses, or nil if it signals success.
+func mutateError(res []*mmlspb.MutateResponse, i int) error {
+	switch {
+	case i >= len(res):
+		return ErrMutateUnknown
+	case res[i].ResponseCode == mmlspb.MutateResponse_OK:
+		return nil
+	default:
+		return MutateError(res[i].ResponseCode)
+	}
+}
+
+// ListAlbums returns the albums in the user's library sorted by the
+// given attribute, or in the server's default order if sortBy is 0.
+// If maxResults is positive, at most that many albums are returned.
+func (c *Client) ListAlbums(sortBy AlbumSortAttribute, descending bool, maxResults int) ([]*Album, error) {
+	return c.ListAlbumsContext(context.Background(), sortBy, descending, maxResults)
+}
+
+// ListAlbumsContext is like ListAlbums but takes a context.
+func (c *Client) ListAlbumsContext(ctx context.Context, sortBy AlbumSortAttribute, descending bool, maxResults int) ([]*Album, error) {
+	res, err := c.getAlbums(ctx, &mmlspb.GetAlbumsRequest{
+		SortOrder: &mmldpb.AlbumSortOrder{
+			Attribute:  mmldpb.AlbumSortOrder_AlbumAttribute(sortBy),
+			Descending: descending,
+		},
+		MaxResults: int32(maxResults),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var albums []*Album
+	convert.Convert(&albums, res.Album)
+	return albums, nil
+}
+
+// ListArtists returns the artists in the user's library, along with
+// their albums, sorted by name.  If maxResults is positive, at most that
+// many artists are returned.
+func (c *Client) ListArtists(descending bool, maxResults int) ([]*Artist, error) {
+	return c.ListArtistsContext(context.Background(), descending, maxResults)
+}
+
+// ListArtistsContext is like ListArtists but takes a context.
+func (c *Client) ListArtistsContext(ctx context.Context, descending bool, maxResults int) ([]*Artist, error) {
+	res, err := c.getArtists(ctx, &mmlspb.GetArtistsRequest{
+		SortOrder:  &mmldpb.ArtistSortOrder{Descending: descending},
+		MaxResults: int32(maxResults),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var artists []*Artist
+	convert.Convert(&artists, res.Artist)
+	return artists, nil
+}
+
+// ListGenres returns the genres in the user's library, along with
+// their albums, sorted by name.  If maxResults is positive, at most that
+// many genres are returned.
+func (c *Client) ListGenres(descending bool, maxResults int) ([]*Genre, error) {
+	return c.ListGenresContext(context.Background(), descending, maxResults)
+}
+
+// ListGenresContext is like ListGenres but takes a context.
+func (c *Client) ListGenresContext(ctx context.Context, descending bool, maxResults int) ([]*Genre, error) {
+	res, err := c.getGenres(ctx, &mmlspb.GetGenresRequest{
+		SortOrder:  &mmldpb.GenreSortOrder{Descending: descending},
+		MaxResults: int32(maxResults),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var genres []*Genre
+	convert.Convert(&genres, res.Genre)
+	return genres, nil
+}
+
+// Stats returns track counts by type and availability status, and
+// summaries of the user's playlists.
+func (c *Client) Stats() (*Stats, error) {
+	return c.StatsContext(context.Background())
+}
+
+// StatsContext is like Stats but takes a context.
+func (c *Client) StatsContext(ctx context.Context) (*Stats, error) {
+	tres, err := c.getAggregationsByTrackType(ctx, &mmlspb.GetAggregationsByTrackTypeRequest{})
+	if err != nil {
+		return nil, err
+	}
+	ares, err := c.getAggregationsByAvailabilityStatus(ctx, &mmlspb.GetAggregationsByAvailabilityStatusRequest{})
+	if err != nil {
+		return nil, err
+	}
+	pres, err := c.getPlaylistAggregations(ctx, &mmlspb.GetPlaylistAggregationsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	stats := &Stats{
+		TrackTypes:           make(map[TrackType]int),
+		AvailabilityStatuses: make(map[AvailabilityStatus]int),
+	}
+	for _, a := range tres.TrackTypeAggregate {
+		stats.TrackTypes[TrackType(a.TrackTypeValue)] += int(a.Count)
+	}
+	for _, a := range ares.AvailabilityStatusAggregate {
+		stats.AvailabilityStatuses[AvailabilityStatus(a.AvailabilityStatus)] += int(a.Count)
+	}
+	convert.Convert(&stats.Playlists, pres.PlaylistAggregate)
+	return stats, nil
+}
+
+// GenerateMix generates a playlist of tracks from the user's library
+// similar to the given seeds, and returns the playlist and its entries.
+func (c *Client) GenerateMix(req *MixRequest) (*Playlist, []*PlaylistEntry, error) {
+	return c.GenerateMixContext(context.Background(), req)
+}
+
+// GenerateMixContext is like GenerateMix but takes a context.
+func (c *Client) GenerateMixContext(ctx context.Context, req *MixRequest) (*Playlist, []*PlaylistEntry, error) {
+	seeds := make([]*mmldpb.MagicPlaylistSeed, len(req.Seeds))
+	for i, s := range req.Seeds {
+		seeds[i] = &mmldpb.MagicPlaylistSeed{
+			SeedType: mmldpb.MagicPlaylistSeed_SeedType(s.Type),
+			Seed:     s.Value,
+		}
+	}
+	res, err := c.magicPlaylist(ctx, &mmlspb.MagicPlaylistRequest{
+		PlaylistName:            req.Name,
+		PlaylistId:              req.PlaylistId,
+		Seed:                    seeds,
+		NumRecommendations:      int32(req.NumRecommendations),
+		IncludeAllTrackMetadata: req.IncludeTracks,
+		ModelName:               req.ModelName,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if res.Playlist == nil {
+		return nil, nil, fmt.Errorf("musicmanager: server generated no mix")
+	}
+	playlist := new(Playlist)
+	convert.Convert(playlist, res.Playlist)
+	entryList := new(PlaylistEntryList)
+	entryList.fromProto(res.PlaylistEntry, "")
+	return playlist, entryList.Items, nil
+}
+
+// Lookup finds the tracks, playlists and playlist entries with the
+// given keys in one call.
+func (c *Client) Lookup(l *Lookup) (*LookupResult, error) {
+	return c.LookupContext(context.Background(), l)
+}
+
+// LookupContext is like Lookup but takes a context.
+func (c *Client) LookupContext(ctx context.Context, l *Lookup) (*LookupResult, error) {
+	req := &mmlspb.BatchLookupRequest{IncludeDeleted: l.IncludeDeleted}
+	for _, k := range l.Tracks {
+		req.Track = append(req.Track, &mmlspb.LookupTrackRequest{Id: k.Id, ClientId: k.ClientId})
+	}
+	for _, k := range l.Playlists {
+		req.Playlist = append(req.Playlist, &mmlspb.LookupPlaylistRequest{Id: k.Id, ClientId: k.ClientId})
+	}
+	for _, k := range l.PlaylistEntries {
+		req.PlaylistEntry = append(req.PlaylistEntry, &mmlspb.LookupPlaylistEntryRequest{Id: k.Id, ClientId: k.ClientId})
+	}
+	switch {
+	case len(req.Playlist) == 0 && len(req.PlaylistEntry) == 0:
+		req.MetadataType = mmlspb.BatchLookupRequest_TRACK
+	case len(req.Track) == 0 && len(req.PlaylistEntry) == 0:
+		req.MetadataType = mmlspb.BatchLookupRequest_PLAYLIST
+	case len(req.Track) == 0 && len(req.Playlist) == 0:
+		req.MetadataType = mmlspb.BatchLookupRequest_PLAYLIST_ENTRY
+	}
+	res, err := c.batchLookup(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	result := &LookupResult{
+		Tracks:          make([]*Track, len(l.Tracks)),
+		Playlists:       make([]*Playlist, len(l.Playlists)),
+		PlaylistEntries: make([]*PlaylistEntry, len(l.PlaylistEntries)),
+	}
+	tracks := tracksFromProto(res.Track)
+	idx := newLookupIndex(len(tracks), func(i int) (string, string) {
+		return tracks[i].Id, tracks[i].ClientId
+	})
+	for i, k := range l.Tracks {
+		if j := idx.find(k); j >= 0 {
+			result.Tracks[i] = tracks[j]
+		}
+	}
+	var playlists []*Playlist
+	convert.Convert(&playlists, res.Playlist)
+	idx = newLookupIndex(len(playlists), func(i int) (string, string) {
+		return playlists[i].Id, playlists[i].ClientId
+	})
+	for i, k := range l.Playlists {
+		if j := idx.find(k); j >= 0 {
+			result.Playlists[i] = playlists[j]
+		}
+	}
+	entries := new(PlaylistEntryList)
+	entries.fromProto(res.PlaylistEntry, "")
+	idx = newLookupIndex(len(entries.Items), func(i int) (string, string) {
+		return entries.Items[i].Id, entries.Items[i].ClientId
+	})
+	for i, k := range l.PlaylistEntries {
+		if j := idx.find(k); j >= 0 {
+			result.PlaylistEntries[i] = entries.Items[j]
+		}
+	}
+	return result, nil
+}
+
+// A lookupIndex finds the items of a batch lookup response by their
+// server or client-side IDs.
+type lookupIndex struct {
+	byID, byClientID map[string]int
+}
+
+// newLookupIndex indexes n items, the IDs of the ith of which are
+// returned by ids(i).
+func newLookupIndex(n int, ids func(i int) (id, clientID string)) *lookupIndex {
+	x := &lookupIndex{
+		byID:       make(map[string]int, n),
+		byClientID: make(map[string]int, n),
+	}
+	for i := n - 1; i >= 0; i-- {
+		id, clientID := ids(i)
+		if id != "" {
+			x.byID[id] = i
+		}
+		if clientID != "" {
+			x.byClientID[clientID] = i
+		}
+	}
+	return x
+}
+
+// find returns the index of the first item identified by k, or -1 if
+// there is none.
+func (x *lookupIndex) find(k LookupKey) int {
+	m, key := x.byID, k.Id
+	if key == "" {
+		m, key = x.byClientID, k.ClientId
+	}
+	if i, ok := m[key]; ok && key != "" {
+		return i
+	}
+	return -1
+}
+
+// LookupTracks returns the undeleted tracks with the given server IDs,
+// with nil for those not found.
+func (c *Client) LookupTracks(ids ...string) ([]*Track, error) {
+	return c.LookupTracksContext(context.Background(), ids...)
+}
+
+// LookupTracksContext is like LookupTracks but takes a context.
+func (c *Client) LookupTracksContext(ctx context.Context, ids ...string) ([]*Track, error) {
+	res, err := c.LookupContext(ctx, &Lookup{Tracks: lookupKeys(ids, false)})
+	if err != nil {
+		return nil, err
+	}
+	return res.Tracks, nil
+}
+
+// LookupTracksByClientId returns the undeleted tracks with the given
+// client IDs, with nil for those not found.  For tracks uploaded with
+// gmusic, the client ID is the checksum of the file's audio data.
+func (c *Client) LookupTracksByClientId(clientIDs ...string) ([]*Track, error) {
+	return c.LookupTracksByClientIdContext(context.Background(), clientIDs...)
+}
+
+// LookupTracksByClientIdContext is like LookupTracksByClientId but
+// takes a context.
+func (c *Client) LookupTracksByClientIdContext(ctx context.Context, clientIDs ...string) ([]*Track, error) {
+	res, err := c.LookupContext(ctx, &Lookup{Tracks: lookupKeys(clientIDs, true)})
+	if err != nil {
+		return nil, err
+	}
+	return res.Tracks, nil
+}
+
+// LookupPlaylists returns the undeleted playlists with the given server
+// IDs, with nil for those not found.
+func (c *Client) LookupPlaylists(ids ...string) ([]*Playlist, error) {
+	return c.LookupPlaylistsContext(context.Background(), ids...)
+}
+
+// LookupPlaylistsContext is like LookupPlaylists but takes a context.
+func (c *Client) LookupPlaylistsContext(ctx context.Context, ids ...string) ([]*Playlist, error) {
+	res, err := c.LookupContext(ctx, &Lookup{Playlists: lookupKeys(ids, false)})
+	if err != nil {
+		return nil, err
+	}
+	return res.Playlists, nil
+}
+
+// LookupPlaylistEntries returns the undeleted playlist entries with the
+// given server IDs, with nil for those not found.
+func (c *Client) LookupPlaylistEntries(ids ...string) ([]*PlaylistEntry, error) {
+	return c.LookupPlaylistEntriesContext(context.Background(), ids...)
+}
+
+// LookupPlaylistEntriesContext is like LookupPlaylistEntries but takes
+// a context.
+func (c *Client) LookupPlaylistEntriesContext(ctx context.Context, ids ...string) ([]*PlaylistEntry, error) {
+	res, err := c.LookupContext(ctx, &Lookup{PlaylistEntries: lookupKeys(ids, false)})
+	if err != nil {
+		return nil, err
+	}
+	return res.PlaylistEntries, nil
+}
+
+// lookupKeys returns lookup keys for the given server IDs, or client
+// IDs if byClientID is true.
+func lookupKeys(ids []string, byClientID bool) []LookupKey {
+	keys := make([]LookupKey, len(ids))
+	for i, id := range ids {
+		if byClientID {
+			keys[i].ClientId = id
+		} else {
+			keys[i].Id = id
+		}
+	}
+	return keys
+}
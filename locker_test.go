@@ -0,0 +1,429 @@
+package musicmanager_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/lxr/go.google.musicmanager"
+	"github.com/lxr/go.google.musicmanager/musicmanagertest"
+)
+
+func TestNoLocker(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	c := newClient(t, s)
+	c.Endpoints.Locker = ""
+	if _, err := c.QueryTracks(nil, ""); err != musicmanager.ErrNoLocker {
+		t.Errorf("QueryTracks without a locker endpoint: got %v, want %v", err, musicmanager.ErrNoLocker)
+	}
+}
+
+func TestQueryTracks(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	s.AddTrack(&musicmanager.Track{Title: "b", Artist: "X", Year: 1990, DurationMillis: 3000, PlayCount: 4}, []byte("aa"))
+	s.AddTrack(&musicmanager.Track{Title: "a", Artist: "Y", Year: 2000, DurationMillis: 2000}, []byte("a"))
+	s.AddTrack(&musicmanager.Track{Title: "c", Artist: "X", Year: 2010}, []byte("a"))
+	c := newClient(t, s)
+
+	l, err := c.QueryTracks(&musicmanager.TrackQuery{
+		Restrictions: []musicmanager.Restriction{
+			{Attribute: musicmanager.SearchArtist, Comparison: musicmanager.Equal, Value: "X"},
+		},
+		SortOrder:  []musicmanager.SortOrder{{Attribute: musicmanager.SortTitle, Descending: true}},
+		MaxResults: 1,
+	}, "")
+	if err != nil {
+		t.Fatalf("QueryTracks: %v", err)
+	}
+	if len(l.Items) != 1 || l.Items[0].Title != "c" || l.PageToken == "" {
+		t.Errorf("artist X by descending title, one per page: got %+v", l)
+	}
+
+	l, err = c.QueryTracks(&musicmanager.TrackQuery{
+		RestrictionSet: &musicmanager.RestrictionSet{
+			Or: true,
+			Restrictions: []musicmanager.Restriction{
+				{Attribute: musicmanager.SearchYear, Comparison: musicmanager.LessThan, Value: "1995"},
+				{Attribute: musicmanager.SearchTitle, Comparison: musicmanager.PartialMatch, Value: "A"},
+			},
+		},
+		SortOrder: []musicmanager.SortOrder{{Attribute: musicmanager.SortDuration}},
+	}, "")
+	if err != nil {
+		t.Fatalf("QueryTracks: %v", err)
+	}
+	if len(l.Items) != 2 || l.Items[0].Title != "a" || l.Items[1].Title != "b" {
+		t.Fatalf("year < 1995 or title ~ A by duration: got %+v", l.Items)
+	}
+	if b := l.Items[1]; b.PlayCount != 4 || b.TrackSize != 2 || b.AvailabilityStatus != musicmanager.StatusAvailable {
+		t.Errorf("track b has play count %d, size %d and status %v, want 4, 2 and %v",
+			b.PlayCount, b.TrackSize, b.AvailabilityStatus, musicmanager.StatusAvailable)
+	}
+
+	l, err = c.QueryTracks(nil, "")
+	if err != nil {
+		t.Fatalf("QueryTracks: %v", err)
+	}
+	l, err = c.QueryTracks(&musicmanager.TrackQuery{UpdatedMin: l.UpdatedMin}, "")
+	if err != nil {
+		t.Fatalf("QueryTracks: %v", err)
+	}
+	if len(l.Items) != 0 {
+		t.Errorf("listed %d tracks updated since the last listing, want 0", len(l.Items))
+	}
+}
+
+func TestListPlaylists(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	a := s.AddTrack(&musicmanager.Track{Title: "a"}, []byte("aa"))
+	b := s.AddTrack(&musicmanager.Track{Title: "b"}, []byte("a"))
+	p := s.AddPlaylist("mine", b, a)
+	s.AddPlaylist("other", a)
+	c := newClient(t, s)
+
+	l, err := c.ListPlaylists(0, "")
+	if err != nil {
+		t.Fatalf("ListPlaylists: %v", err)
+	}
+	if len(l.Items) != 2 || l.Items[0].Name != "mine" || l.Items[0].PlaylistType != musicmanager.UserGeneratedPlaylist {
+		t.Errorf("ListPlaylists: got %+v", l.Items)
+	}
+	el, err := c.ListPlaylistEntries(p, 0, "")
+	if err != nil {
+		t.Fatalf("ListPlaylistEntries: %v", err)
+	}
+	if len(el.Items) != 2 || el.Items[0].TrackId != b || el.Items[1].TrackId != a {
+		t.Fatalf("ListPlaylistEntries: got %+v, want entries for %s and %s", el.Items, b, a)
+	}
+	if tr := el.Items[1].Track; tr == nil || tr.Title != "a" || tr.TrackSize != 2 {
+		t.Errorf("ListPlaylistEntries: entry for a has track %+v", tr)
+	}
+
+	all, err := c.ListPlaylistEntries("", el.UpdatedMin, "")
+	if err != nil {
+		t.Fatalf("ListPlaylistEntries: %v", err)
+	}
+	if len(all.Items) != 1 {
+		t.Errorf("listed %d entries of all playlists updated since the first, want 1", len(all.Items))
+	}
+	l, err = c.ListPlaylists(l.UpdatedMin, "")
+	if err != nil {
+		t.Fatalf("ListPlaylists: %v", err)
+	}
+	if len(l.Items) != 0 {
+		t.Errorf("listed %d playlists updated since the last listing, want 0", len(l.Items))
+	}
+}
+
+func TestMutateTracks(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	a := s.AddTrack(&musicmanager.Track{Title: "a", Artist: "Tpyo", Album: "x"}, []byte("aa"))
+	c := newClient(t, s)
+
+	errs := c.UpdateTracks([]*musicmanager.Track{
+		{Id: a, Artist: "Typo", Rating: musicmanager.FiveStars},
+		{Id: "no such track"},
+	})
+	if errs[0] != nil || errs[1] != musicmanager.ErrInvalidMutation {
+		t.Fatalf("UpdateTracks: got errors %v, want nil and %v", errs, musicmanager.ErrInvalidMutation)
+	}
+	tr, _, _ := s.Track(a)
+	if tr.Title != "a" || tr.Artist != "Typo" || tr.Album != "x" || tr.Rating != musicmanager.FiveStars {
+		t.Errorf("after update: got %q by %q on %q rated %v, want %q by %q on %q rated %v",
+			tr.Title, tr.Artist, tr.Album, tr.Rating, "a", "Typo", "x", musicmanager.FiveStars)
+	}
+
+	c.DetectConflicts = true
+	errs = c.UpdateTracks([]*musicmanager.Track{{Id: a, Title: "b", LastModifiedTimestamp: 1}})
+	if errs[0] != musicmanager.ErrConflict {
+		t.Errorf("UpdateTracks with a stale timestamp: got %v, want %v", errs[0], musicmanager.ErrConflict)
+	}
+	errs = c.UpdateTracks([]*musicmanager.Track{{Id: a, Title: "b", LastModifiedTimestamp: tr.LastModifiedTimestamp}})
+	if errs[0] != nil {
+		t.Fatalf("UpdateTracks: %v", errs[0])
+	}
+	if tr, _, _ = s.Track(a); tr.Title != "b" || tr.Album != "x" {
+		t.Errorf("after second update: got %q on %q, want %q on %q", tr.Title, tr.Album, "b", "x")
+	}
+
+	if errs = c.DeleteTracks([]string{a}); errs[0] != nil {
+		t.Fatalf("DeleteTracks: %v", errs[0])
+	}
+	if l, err := c.QueryTracks(nil, ""); err != nil || len(l.Items) != 0 {
+		t.Errorf("QueryTracks after DeleteTracks: got %+v, %v", l, err)
+	}
+	if errs = c.UndeleteTracks([]string{a}); errs[0] != nil {
+		t.Fatalf("UndeleteTracks: %v", errs[0])
+	}
+	if l, err := c.QueryTracks(nil, ""); err != nil || len(l.Items) != 1 {
+		t.Errorf("QueryTracks after UndeleteTracks: got %+v, %v", l, err)
+	}
+}
+
+func TestMutatePlaylists(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	a := s.AddTrack(&musicmanager.Track{Title: "a"}, []byte("aa"))
+	b := s.AddTrack(&musicmanager.Track{Title: "b"}, []byte("aa"))
+	c := newClient(t, s)
+
+	ids, errs := c.CreatePlaylists([]*musicmanager.Playlist{{Name: "one"}})
+	if errs[0] != nil {
+		t.Fatalf("CreatePlaylists: %v", errs[0])
+	}
+	p := ids[0]
+	if errs := c.UpdatePlaylists([]*musicmanager.Playlist{{Id: p, Name: "renamed"}}, true); errs[0] != nil {
+		t.Fatalf("UpdatePlaylists: %v", errs[0])
+	}
+	pl, err := c.ListPlaylists(0, "")
+	if err != nil {
+		t.Fatalf("ListPlaylists: %v", err)
+	}
+	if len(pl.Items) != 1 || pl.Items[0].Name != "renamed" {
+		t.Errorf("ListPlaylists after renaming: got %+v", pl.Items)
+	}
+
+	eids, errs := c.CreatePlaylistEntries([]*musicmanager.PlaylistEntry{
+		{PlaylistId: p, TrackId: a},
+		{PlaylistId: p, TrackId: b},
+		{PlaylistId: p, TrackId: b},
+		{PlaylistId: p, TrackId: "no such track"},
+	})
+	for i, err := range errs[:3] {
+		if err != nil {
+			t.Fatalf("CreatePlaylistEntries: entry %d: %v", i, err)
+		}
+	}
+	if errs[3] != musicmanager.ErrInvalidMutation {
+		t.Errorf("CreatePlaylistEntries for a missing track: got %v, want %v", errs[3], musicmanager.ErrInvalidMutation)
+	}
+	first, errs := c.CreatePlaylistEntries([]*musicmanager.PlaylistEntry{
+		{PlaylistId: p, TrackId: a, PlaceBeforeEntryId: eids[0]},
+	})
+	if errs[0] != nil {
+		t.Fatalf("CreatePlaylistEntries: %v", errs[0])
+	}
+	if errs := c.UpdatePlaylistEntries([]*musicmanager.PlaylistEntry{{Id: eids[2], PlaceAfterEntryId: first[0]}}); errs[0] != nil {
+		t.Fatalf("UpdatePlaylistEntries: %v", errs[0])
+	}
+	if errs := c.DeletePlaylistEntries([]*musicmanager.PlaylistEntry{{Id: eids[1]}}); errs[0] != nil {
+		t.Fatalf("DeletePlaylistEntries: %v", errs[0])
+	}
+	el, err := c.ListPlaylistEntries(p, 0, "")
+	if err != nil {
+		t.Fatalf("ListPlaylistEntries: %v", err)
+	}
+	want := []string{first[0], eids[2], eids[0]}
+	var got []string
+	for _, e := range el.Items {
+		got = append(got, e.Id)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("entries in order %v, want %v", got, want)
+	}
+
+	c.DetectConflicts = true
+	errs = c.UpdatePlaylists([]*musicmanager.Playlist{{Id: p, Name: "x", LastModifiedTimestamp: 1}}, true)
+	if errs[0] != musicmanager.ErrConflict {
+		t.Errorf("UpdatePlaylists with a stale timestamp: got %v, want %v", errs[0], musicmanager.ErrConflict)
+	}
+	if errs := c.DeletePlaylists([]string{p}); errs[0] != nil {
+		t.Fatalf("DeletePlaylists: %v", errs[0])
+	}
+	if el, err := c.ListPlaylistEntries(p, 0, ""); err != nil || len(el.Items) != 0 {
+		t.Errorf("ListPlaylistEntries after DeletePlaylists: got %+v, %v", el, err)
+	}
+}
+
+func TestBrowse(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	s.AddTrack(&musicmanager.Track{Title: "a", Artist: "X", Album: "A1", Genre: "Rock"}, nil)
+	s.AddTrack(&musicmanager.Track{Title: "b", Artist: "X", Album: "A2", Genre: "Rock"}, nil)
+	s.AddTrack(&musicmanager.Track{Title: "c", Artist: "Y", Album: "A2", Genre: "Pop"}, nil)
+	c := newClient(t, s)
+
+	albums, err := c.ListAlbums(musicmanager.SortAlbumName, true, 0)
+	if err != nil {
+		t.Fatalf("ListAlbums: %v", err)
+	}
+	if len(albums) != 2 || albums[0].Name != "A2" || albums[0].TrackCount != 2 {
+		t.Errorf("ListAlbums by descending name: got %+v", albums)
+	}
+	artists, err := c.ListArtists(false, 1)
+	if err != nil {
+		t.Fatalf("ListArtists: %v", err)
+	}
+	if len(artists) != 1 || artists[0].Name != "X" || len(artists[0].Albums) != 2 || artists[0].TotalTrackCount != 2 {
+		t.Errorf("ListArtists, at most one: got %+v", artists)
+	}
+	genres, err := c.ListGenres(false, 0)
+	if err != nil {
+		t.Fatalf("ListGenres: %v", err)
+	}
+	if len(genres) != 2 || genres[0].Name != "Pop" || len(genres[0].Albums) != 1 || genres[0].Albums[0].Name != "A2" {
+		t.Errorf("ListGenres: got %+v", genres)
+	}
+}
+
+func TestStats(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	a := s.AddTrack(&musicmanager.Track{Title: "a", TrackType: musicmanager.Purchased}, nil)
+	s.AddTrack(&musicmanager.Track{Title: "b", TrackType: musicmanager.Local}, nil)
+	s.AddPlaylist("p", a, a)
+	c := newClient(t, s)
+	if _, errs := c.ImportTracks([]*musicmanager.Track{{ClientId: "x", Title: "x", TrackType: musicmanager.Local}}); errs[0] != nil {
+		t.Fatalf("ImportTracks: %v", errs[0])
+	}
+
+	st, err := c.Stats()
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	if n := st.TrackTypes[musicmanager.Local]; n != 2 {
+		t.Errorf("%d local tracks, want 2", n)
+	}
+	if n := st.AvailabilityStatuses[musicmanager.StatusAvailable]; n != 2 {
+		t.Errorf("%d available tracks, want 2", n)
+	}
+	if n := st.AvailabilityStatuses[musicmanager.StatusUploadRequested]; n != 1 {
+		t.Errorf("%d tracks awaiting upload, want 1", n)
+	}
+	if len(st.Playlists) != 1 || st.Playlists[0].TrackCount != 2 {
+		t.Errorf("playlist statistics: got %+v, want one playlist of 2 tracks", st.Playlists)
+	}
+}
+
+func TestListDynamicPlaylistEntries(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	s.PageSize = 1
+	a := s.AddTrack(&musicmanager.Track{Title: "a", Rating: musicmanager.FiveStars}, []byte("x"))
+	s.AddTrack(&musicmanager.Track{Title: "b"}, nil)
+	b := s.AddTrack(&musicmanager.Track{Title: "c", Rating: musicmanager.FiveStars}, nil)
+	c := newClient(t, s)
+
+	l, err := c.ListDynamicPlaylistEntries(musicmanager.ThumbsUpPlaylist, true, "")
+	if err != nil {
+		t.Fatalf("ListDynamicPlaylistEntries: %v", err)
+	}
+	if len(l.Items) != 1 || l.Items[0].TrackId != a || l.PageToken == "" {
+		t.Fatalf("first page of thumbs up: got %+v", l)
+	}
+	if tr := l.Items[0].Track; tr == nil || tr.TrackSize != 1 {
+		t.Errorf("first page of thumbs up: entry has track %+v", tr)
+	}
+	l, err = c.ListDynamicPlaylistEntries(musicmanager.ThumbsUpPlaylist, false, l.PageToken)
+	if err != nil {
+		t.Fatalf("ListDynamicPlaylistEntries: %v", err)
+	}
+	if len(l.Items) != 1 || l.Items[0].TrackId != b || l.Items[0].Track != nil || l.PageToken != "" {
+		t.Errorf("second page of thumbs up without tracks: got %+v", l)
+	}
+	l, err = c.ListDynamicPlaylistEntries(musicmanager.RecentlyAddedPlaylist, false, "")
+	if err != nil {
+		t.Fatalf("ListDynamicPlaylistEntries: %v", err)
+	}
+	if len(l.Items) == 0 || l.Items[0].TrackId != b {
+		t.Errorf("recently added: got %+v, want %s first", l.Items, b)
+	}
+	if _, err := c.ListDynamicPlaylistEntries(musicmanager.DynamicPlaylist(99), false, ""); err != musicmanager.ErrDynamicPlaylistNotOK {
+		t.Errorf("ListDynamicPlaylistEntries of an unknown playlist: got %v, want %v", err, musicmanager.ErrDynamicPlaylistNotOK)
+	}
+}
+
+func TestGenerateMix(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	a := s.AddTrack(&musicmanager.Track{Title: "a", Artist: "X"}, []byte("x"))
+	s.AddTrack(&musicmanager.Track{Title: "b", Artist: "Y", Album: "Z"}, nil)
+	s.AddTrack(&musicmanager.Track{Title: "c", Artist: "X"}, nil)
+	c := newClient(t, s)
+
+	p, es, err := c.GenerateMix(&musicmanager.MixRequest{
+		Name: "mix",
+		Seeds: []musicmanager.Seed{
+			{Type: musicmanager.TrackSeed, Value: a},
+			{Type: musicmanager.AlbumSeed, Value: "Z"},
+		},
+		IncludeTracks: true,
+	})
+	if err != nil {
+		t.Fatalf("GenerateMix: %v", err)
+	}
+	if p.Name != "mix" || p.PlaylistType != musicmanager.MagicPlaylist {
+		t.Errorf("GenerateMix: got playlist %+v", p)
+	}
+	if len(es) != 3 {
+		t.Fatalf("GenerateMix: got %d entries, want 3", len(es))
+	}
+	if tr := es[1].Track; tr == nil || tr.Title != "b" {
+		t.Errorf("GenerateMix: second entry has track %+v, want b", tr)
+	}
+
+	p2, es, err := c.GenerateMix(&musicmanager.MixRequest{
+		PlaylistId:         p.Id,
+		Seeds:              []musicmanager.Seed{{Type: musicmanager.ArtistSeed, Value: "Y"}},
+		NumRecommendations: 1,
+	})
+	if err != nil {
+		t.Fatalf("GenerateMix: %v", err)
+	}
+	if p2.Id != p.Id || len(es) != 1 || es[0].Track != nil {
+		t.Errorf("regenerating: got playlist %s with %+v, want %s with one entry without track", p2.Id, es, p.Id)
+	}
+	el, err := c.ListPlaylistEntries(p.Id, 0, "")
+	if err != nil {
+		t.Fatalf("ListPlaylistEntries: %v", err)
+	}
+	if len(el.Items) != 1 {
+		t.Errorf("regenerated mix has %d entries, want 1", len(el.Items))
+	}
+}
+
+func TestLookup(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	a := s.AddTrack(&musicmanager.Track{Title: "a", ClientId: "sum-a"}, []byte("x"))
+	b := s.AddTrack(&musicmanager.Track{Title: "b"}, nil)
+	p := s.AddPlaylist("p", a)
+	c := newClient(t, s)
+
+	ts, err := c.LookupTracks(b, "no such track", a, b)
+	if err != nil {
+		t.Fatalf("LookupTracks: %v", err)
+	}
+	if len(ts) != 4 || ts[0] == nil || ts[0].Title != "b" || ts[1] != nil ||
+		ts[2] == nil || ts[2].TrackSize != 1 || ts[3] == nil || ts[3].Id != b {
+		t.Errorf("LookupTracks(b, missing, a, b): got %+v", ts)
+	}
+	ts, err = c.LookupTracksByClientId("sum-a", "sum-b")
+	if err != nil {
+		t.Fatalf("LookupTracksByClientId: %v", err)
+	}
+	if ts[0] == nil || ts[0].Id != a || ts[1] != nil {
+		t.Errorf("LookupTracksByClientId(sum-a, sum-b): got %+v", ts)
+	}
+
+	if errs := c.DeleteTracks([]string{b}); errs[0] != nil {
+		t.Fatalf("DeleteTracks: %v", errs[0])
+	}
+	r, err := c.Lookup(&musicmanager.Lookup{
+		Tracks:         []musicmanager.LookupKey{{Id: b}},
+		Playlists:      []musicmanager.LookupKey{{Id: p}},
+		IncludeDeleted: true,
+	})
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	if r.Tracks[0] == nil || !r.Tracks[0].Deleted || r.Playlists[0] == nil || r.Playlists[0].Name != "p" {
+		t.Errorf("Lookup including deleted: got %+v", r)
+	}
+	if ts, err := c.LookupTracks(b); err != nil || ts[0] != nil {
+		t.Errorf("LookupTracks of a deleted track: got %+v, %v", ts, err)
+	}
+}
@@ -0,0 +1,244 @@
+// Package mp3sample cuts audio samples out of MP3 streams for answering
+// the upload challenges of the Music Manager service.
+//
+// When importing a track, the server can ask for a sample of the track
+// instead of accepting its metadata outright, naming the window of the
+// track it wants in milliseconds.  The official client answers with
+// that window of the track encoded as 128 kbps MP3, which the server
+// uses to match the track against its catalogue.  Sample does the same
+// for MP3 tracks, slicing the stream on frame boundaries and handing
+// the result to a Transcoder if it is not already in the right format.
+// SampleFile does it for tracks of other formats with ffmpeg.
+package mp3sample
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+// SampleBitRate is the bitrate of the samples expected by the server,
+// in kbps.
+const SampleBitRate = 128
+
+// A Transcoder re-encodes the given MP3 data as SampleBitRate kbps MP3.
+type Transcoder func(mp3 []byte) ([]byte, error)
+
+// Sample returns the frames of the MP3 stream read from r that start
+// within the window of the given duration beginning start milliseconds
+// into the stream.  If any of the frames are not SampleBitRate kbps
+// layer III frames, the sample is passed through t.  If t is nil, the
+// sample is returned as is.
+func Sample(r io.Reader, start, duration int, t Transcoder) ([]byte, error) {
+	sample, ok, err := cut(r, start, duration)
+	if err != nil || ok || t == nil || len(sample) == 0 {
+		return sample, err
+	}
+	return t(sample)
+}
+
+// Cut returns the frames of the MP3 stream read from r that start within
+// the window of the given duration beginning start milliseconds into
+// the stream.  Any ID3v2 tag at the start of the stream, VBR header
+// frames and data between frames are skipped.
+func Cut(r io.Reader, start, duration int) ([]byte, error) {
+	sample, _, err := cut(r, start, duration)
+	return sample, err
+}
+
+// FFmpeg is a Transcoder that runs the ffmpeg command.  It fails if
+// ffmpeg cannot be found in the PATH.
+func FFmpeg(mp3 []byte) ([]byte, error) {
+	return ffmpeg(bytes.NewReader(mp3), "-f", "mp3", "-i", "pipe:0")
+}
+
+// SampleFile uses the ffmpeg command to cut the window of the given
+// duration beginning start milliseconds into the named audio file and
+// encode it as SampleBitRate kbps MP3.  Unlike Sample, it works for any
+// format ffmpeg can decode.  It fails if ffmpeg cannot be found in the
+// PATH.
+func SampleFile(name string, start, duration int) ([]byte, error) {
+	return ffmpeg(nil,
+		"-ss", fmt.Sprintf("%d.%03d", start/1000, start%1000),
+		"-t", fmt.Sprintf("%d.%03d", duration/1000, duration%1000),
+		"-i", name,
+	)
+}
+
+// ffmpeg runs the ffmpeg command with the given input options and
+// standard input, returning its output encoded as SampleBitRate kbps
+// MP3 without any tags.
+func ffmpeg(stdin io.Reader, input ...string) ([]byte, error) {
+	args := append([]string{"-hide_banner", "-loglevel", "error"}, input...)
+	args = append(args,
+		"-vn", "-map_metadata", "-1",
+		"-codec:a", "libmp3lame", "-b:a", fmt.Sprint(SampleBitRate, "k"),
+		"-write_xing", "0", "-id3v2_version", "0",
+		"-f", "mp3", "pipe:1",
+	)
+	cmd := exec.Command("ffmpeg", args...)
+	cmd.Stdin = stdin
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) > 0 {
+			err = fmt.Errorf("ffmpeg: %v: %s", err, msg)
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+// cut implements Cut, additionally reporting whether all the frames of
+// the sample are SampleBitRate kbps layer III frames.
+func cut(r io.Reader, start, duration int) (sample []byte, ok bool, err error) {
+	br := bufio.NewReader(r)
+	if err := skipID3(br); err != nil {
+		return nil, false, err
+	}
+	// Times are kept in microseconds to avoid accumulating rounding
+	// errors over the length of the stream.
+	startUs := int64(start) * 1000
+	endUs := startUs + int64(duration)*1000
+	var elapsed int64
+	buf := new(bytes.Buffer)
+	ok = true
+	first := true
+	for elapsed < endUs {
+		hdr, err := br.Peek(4)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return nil, false, err
+		}
+		h, valid := parseHeader(hdr)
+		if !valid {
+			// Not a frame; resynchronize.
+			br.Discard(1)
+			continue
+		}
+		frame := make([]byte, h.size)
+		if _, err := io.ReadFull(br, frame); err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return nil, false, err
+		}
+		if first {
+			first = false
+			if isVBRHeader(frame) {
+				continue
+			}
+		}
+		if elapsed >= startUs {
+			buf.Write(frame)
+			ok = ok && h.layer == 3 && h.bitRate == SampleBitRate
+		}
+		elapsed += int64(h.samples) * 1e6 / int64(h.sampleRate)
+	}
+	return buf.Bytes(), ok, nil
+}
+
+// skipID3 discards the ID3v2 tag at the start of br, if any.
+func skipID3(br *bufio.Reader) error {
+	hdr, err := br.Peek(10)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if string(hdr[:3]) != "ID3" {
+		return nil
+	}
+	// The tag size is a 28-bit "syncsafe" integer that excludes
+	// the header and the optional footer.
+	n := 10 + (int(hdr[6]&0x7f)<<21 | int(hdr[7]&0x7f)<<14 | int(hdr[8]&0x7f)<<7 | int(hdr[9]&0x7f))
+	if hdr[5]&0x10 != 0 {
+		n += 10
+	}
+	if _, err := br.Discard(n); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// isVBRHeader reports whether frame is a Xing, Info or VBRI header
+// frame, which carries no audio.
+func isVBRHeader(frame []byte) bool {
+	if len(frame) > 64 {
+		frame = frame[:64]
+	}
+	return bytes.Contains(frame, []byte("Xing")) ||
+		bytes.Contains(frame, []byte("Info")) ||
+		bytes.Contains(frame, []byte("VBRI"))
+}
+
+// A header holds the fields of an MPEG audio frame header needed to
+// walk the stream.
+type header struct {
+	layer      int // 1, 2 or 3
+	bitRate    int // in kbps
+	sampleRate int // in Hz
+	samples    int // per frame
+	size       int // of the whole frame in bytes
+}
+
+// Bitrates in kbps, indexed by MPEG-1 layer or MPEG-2/2.5 layer and the
+// bitrate index of the header.
+var (
+	bitRatesV1 = [4][15]int{
+		1: {0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		2: {0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		3: {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	}
+	bitRatesV2 = [4][15]int{
+		1: {0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		2: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		3: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	}
+)
+
+// Sample rates in Hz, indexed by the version and sample rate index of
+// the header.
+var sampleRates = [4][3]int{
+	0: {11025, 12000, 8000},  // MPEG-2.5
+	2: {22050, 24000, 16000}, // MPEG-2
+	3: {44100, 48000, 32000}, // MPEG-1
+}
+
+// parseHeader parses the 4-byte frame header at the start of b.  It
+// reports false if b does not start with a valid header.  Free-format
+// frames are not supported.
+func parseHeader(b []byte) (h header, ok bool) {
+	if b[0] != 0xff || b[1]&0xe0 != 0xe0 {
+		return h, false
+	}
+	version := int(b[1] >> 3 & 3)
+	h.layer = 4 - int(b[1]>>1&3)
+	brIndex := int(b[2] >> 4)
+	srIndex := int(b[2] >> 2 & 3)
+	padding := int(b[2] >> 1 & 1)
+	if version == 1 || h.layer == 4 || brIndex == 0 || brIndex == 15 || srIndex == 3 {
+		return h, false
+	}
+	h.sampleRate = sampleRates[version][srIndex]
+	if version == 3 {
+		h.bitRate = bitRatesV1[h.layer][brIndex]
+	} else {
+		h.bitRate = bitRatesV2[h.layer][brIndex]
+	}
+	switch {
+	case h.layer == 1:
+		h.samples = 384
+		h.size = (12*h.bitRate*1000/h.sampleRate + padding) * 4
+	case h.layer == 3 && version != 3:
+		h.samples = 576
+		h.size = 72*h.bitRate*1000/h.sampleRate + padding
+	default:
+		h.samples = 1152
+		h.size = 144*h.bitRate*1000/h.sampleRate + padding
+	}
+	return h, true
+}
@@ -0,0 +1,88 @@
+package mp3sample_test
+
+import (
+	"bytes"
+	"os/exec"
+	"testing"
+
+	"github.com/lxr/go.google.musicmanager/mp3sample"
+)
+
+// frame returns an MPEG-1 layer III 44.1 kHz frame of the given bitrate
+// (128 or 192 kbps) filled with the given byte.  Each frame lasts
+// 1152/44100 s, or about 26.12 ms.
+func frame(kbps int, fill byte) []byte {
+	index := map[int]byte{128: 9, 192: 11}[kbps]
+	f := bytes.Repeat([]byte{fill}, 144*kbps*1000/44100)
+	f[0], f[1], f[2], f[3] = 0xff, 0xfb, index<<4, 0x00
+	return f
+}
+
+// stream returns an MP3 stream of an ID3v2 tag, a Xing header frame
+// and 100 128 kbps frames filled with 1 to 100, with junk between the
+// 51st and 52nd frame.
+func stream() []byte {
+	var b bytes.Buffer
+	b.Write([]byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0, 20})
+	b.Write(make([]byte, 20))
+	x := frame(128, 0)
+	copy(x[36:], "Xing")
+	b.Write(x)
+	for i := 0; i < 100; i++ {
+		b.Write(frame(128, byte(i+1)))
+		if i == 50 {
+			b.WriteString("junk")
+		}
+	}
+	return b.Bytes()
+}
+
+func TestCut(t *testing.T) {
+	// The frames filled with 40 to 77 start within [1000 ms,
+	// 2000 ms).
+	s, err := mp3sample.Cut(bytes.NewReader(stream()), 1000, 1000)
+	if err != nil {
+		t.Fatalf("Cut: %v", err)
+	}
+	n := len(frame(128, 0))
+	if len(s) != 38*n || s[4] != 40 || s[len(s)-1] != 77 {
+		t.Errorf("Cut: got %d bytes from frame %d to %d, want %d from 40 to 77", len(s), s[4], s[len(s)-1], 38*n)
+	}
+	s, err = mp3sample.Cut(bytes.NewReader(stream()), 100000, 100)
+	if err != nil {
+		t.Fatalf("Cut: %v", err)
+	}
+	if len(s) != 0 {
+		t.Errorf("Cut past the end: got %d bytes", len(s))
+	}
+}
+
+func TestSampleTranscodes(t *testing.T) {
+	transcoded := false
+	tc := func(b []byte) ([]byte, error) {
+		transcoded = true
+		return b, nil
+	}
+	if _, err := mp3sample.Sample(bytes.NewReader(stream()), 0, 100, tc); err != nil {
+		t.Fatalf("Sample: %v", err)
+	}
+	if transcoded {
+		t.Error("Sample transcoded a 128 kbps sample")
+	}
+	high := append(frame(192, 1), frame(192, 2)...)
+	if _, err := mp3sample.Sample(bytes.NewReader(high), 0, 100, tc); err != nil {
+		t.Fatalf("Sample: %v", err)
+	}
+	if !transcoded {
+		t.Error("Sample did not transcode a 192 kbps sample")
+	}
+}
+
+func TestSampleFile(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg not found")
+	}
+	if _, err := mp3sample.SampleFile("no such file", 0, 1000); err == nil {
+		t.Error("SampleFile of a missing file succeeded")
+	}
+}
@@ -0,0 +1,683 @@
+// This file implements the HTTP handlers of the fake Music Manager
+// services.
+
+package musicmanagertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/lxr/go.google.musicmanager"
+
+	convert "github.com/lxr/go.google.musicmanager/internal/convert"
+	mmddpb "github.com/lxr/go.google.musicmanager/internal/download_proto/data"
+	mmdspb "github.com/lxr/go.google.musicmanager/internal/download_proto/service"
+	mmldpb "github.com/lxr/go.google.musicmanager/internal/locker_proto/data"
+	mmudpb "github.com/lxr/go.google.musicmanager/internal/upload_proto/data"
+	mmuspb "github.com/lxr/go.google.musicmanager/internal/upload_proto/service"
+)
+
+// handleUploadService serves the protobuf calls under /upsj/.
+func (s *Server) handleUploadService(w http.ResponseWriter, r *http.Request) {
+	buf, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := new(mmuspb.UploadResponse)
+	switch endpoint := strings.TrimPrefix(r.URL.Path, "/upsj/"); endpoint {
+	case "upauth":
+		req := new(mmuspb.UpAuthRequest)
+		if err = proto.Unmarshal(buf, req); err == nil {
+			s.devices[req.UploaderId] = req.FriendlyName
+			res.ResponseType = mmuspb.UploadResponse_AUTH_RESPONSE
+			res.AuthStatus = mmuspb.UploadResponse_OK
+		}
+	case "metadata":
+		req := new(mmuspb.UploadMetadataRequest)
+		if err = proto.Unmarshal(buf, req); err == nil {
+			res.ResponseType = mmuspb.UploadResponse_METADATA_RESPONSE
+			res.MetadataResponse = s.uploadMetadata(req)
+		}
+	case "sample":
+		req := new(mmuspb.UploadSampleRequest)
+		if err = proto.Unmarshal(buf, req); err == nil {
+			res.ResponseType = mmuspb.UploadResponse_SAMPLE_RESPONSE
+			res.SampleResponse = s.uploadSample(req)
+		}
+	case "playlist":
+		req := new(mmuspb.UploadPlaylistRequest)
+		if err = proto.Unmarshal(buf, req); err == nil {
+			res.ResponseType = mmuspb.UploadResponse_PLAYLIST_RESPONSE
+			res.PlaylistResponse = s.uploadPlaylists(req)
+		}
+	case "playlistentry":
+		req := new(mmuspb.UploadPlaylistEntryRequest)
+		if err = proto.Unmarshal(buf, req); err == nil {
+			res.ResponseType = mmuspb.UploadResponse_PLAYLIST_ENTRY_RESPONSE
+			res.PlaylistEntryResponse = s.uploadPlaylistEntries(req)
+		}
+	case "clientstate":
+		req := new(mmuspb.ClientStateRequest)
+		if err = proto.Unmarshal(buf, req); err == nil {
+			res.ResponseType = mmuspb.UploadResponse_CLIENT_STATE_RESPONSE
+			res.ClientstateResponse = s.clientState()
+		}
+	case "getjobs":
+		req := new(mmuspb.GetJobsRequest)
+		if err = proto.Unmarshal(buf, req); err == nil {
+			res.ResponseType = mmuspb.UploadResponse_GETJOBS_RESPONSE
+			res.GetjobsResponse = s.getJobs()
+		}
+	case "uploadstate":
+		req := new(mmuspb.UpdateUploadStateRequest)
+		if err = proto.Unmarshal(buf, req); err == nil {
+			res.ResponseType = mmuspb.UploadResponse_UPDATE_UPLOAD_STATE_RESPONSE
+			s.uploadState = musicmanager.UploadState(req.State)
+		}
+	case "deleteuploadrequested":
+		req := new(mmuspb.DeleteUploadRequestedRequest)
+		if err = proto.Unmarshal(buf, req); err == nil {
+			res.ResponseType = mmuspb.UploadResponse_DELETE_UPLOAD_REQUESTED_RESPONSE
+			for id, trk := range s.tracks {
+				if trk.AvailabilityStatus == mmldpb.Track_UPLOAD_REQUESTED {
+					delete(s.tracks, id)
+				}
+			}
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s.Policy != nil {
+		res.Policy = new(mmudpb.ClientPolicy)
+		convert.Convert(res.Policy, s.Policy)
+	}
+	writeProto(w, res)
+}
+
+// uploadMetadata adds the tracks in req to the library, requesting
+// either an audio sample or an upload for each of them.
+func (s *Server) uploadMetadata(req *mmuspb.UploadMetadataRequest) *mmuspb.UploadMetadataResponse {
+	res := new(mmuspb.UploadMetadataResponse)
+	for _, t := range req.Track {
+		tres := &mmuspb.TrackSampleResponse{ClientTrackId: t.ClientId}
+		if trk := s.findByClientID(t.ClientId); trk != nil {
+			tres.ResponseCode = mmuspb.TrackSampleResponse_ALREADY_EXISTS
+			tres.ServerTrackId = trk.Id
+			res.TrackSampleResponse = append(res.TrackSampleResponse, tres)
+			continue
+		}
+		if len(s.tracks) >= s.TrackLimit {
+			tres.ResponseCode = mmuspb.TrackSampleResponse_TRACK_COUNT_LIMIT_REACHED
+			res.TrackSampleResponse = append(res.TrackSampleResponse, tres)
+			continue
+		}
+		trk := s.newTrack()
+		id, created := trk.Id, trk.CreationTimestamp
+		proto.Merge(trk.Track, t)
+		trk.Id = id
+		trk.CreationTimestamp = created
+		trk.LastModifiedTimestamp = created
+		trk.UploaderId = req.UploaderId
+		trk.AvailabilityStatus = mmldpb.Track_UPLOAD_REQUESTED
+		if s.RequestSamples {
+			res.SignedChallengeInfo = append(res.SignedChallengeInfo, &mmudpb.SignedChallengeInfo{
+				ChallengeInfo: &mmudpb.ChallengeInfo{
+					ClientTrackId:      t.ClientId,
+					StartMillis:        15000,
+					DurationMillis:     15000,
+					ChallengeUserId:    req.UploaderId,
+					ChallengeTimestamp: created,
+				},
+				Signature: []byte(id),
+			})
+			continue
+		}
+		tres.ResponseCode = mmuspb.TrackSampleResponse_UPLOAD_REQUESTED
+		tres.ServerTrackId = id
+		res.TrackSampleResponse = append(res.TrackSampleResponse, tres)
+	}
+	return res
+}
+
+// uploadPlaylists creates the playlists in req.  Only the create
+// operation is supported, and the request must name its uploader.
+func (s *Server) uploadPlaylists(req *mmuspb.UploadPlaylistRequest) *mmuspb.UploadPlaylistResponse {
+	res := new(mmuspb.UploadPlaylistResponse)
+	for _, p := range req.Playlist {
+		pres := &mmuspb.PlaylistResponse{
+			ClientId:       p.ClientId,
+			ResponseStatus: new(mmudpb.ResponseStatus),
+		}
+		res.PlaylistResponse = append(res.PlaylistResponse, pres)
+		if !isCreate(req.UploadOperation) || req.UploaderId == "" {
+			pres.ResponseStatus.ResponseCode = mmudpb.ResponseStatus_SOFT_ERROR
+			continue
+		}
+		if old := s.findPlaylistByClientID(p.ClientId); old != nil {
+			pres.ResponseStatus.ResponseCode = mmudpb.ResponseStatus_ALREADY_EXISTS
+			pres.ServerId = old.Id
+			continue
+		}
+		pl := s.newPlaylist()
+		pl.ClientId = p.ClientId
+		pl.Name = p.Name
+		pl.PlaylistType = p.PlaylistType
+		if pl.PlaylistType == mmldpb.Playlist_DEFAULT {
+			pl.PlaylistType = mmldpb.Playlist_USER_GENERATED
+		}
+		pres.ResponseStatus.ResponseCode = mmudpb.ResponseStatus_OK
+		pres.ServerId = pl.Id
+	}
+	return res
+}
+
+// uploadPlaylistEntries appends the entries in req to their playlists.
+// Only the create operation is supported, and the request must name its
+// uploader.
+func (s *Server) uploadPlaylistEntries(req *mmuspb.UploadPlaylistEntryRequest) *mmuspb.UploadPlaylistEntryResponse {
+	res := new(mmuspb.UploadPlaylistEntryResponse)
+	for _, e := range req.PlaylistEntry {
+		eres := &mmuspb.PlaylistEntryResponse{
+			ClientId:       e.ClientId,
+			ResponseStatus: new(mmudpb.ResponseStatus),
+		}
+		res.PlaylistEntryResponse = append(res.PlaylistEntryResponse, eres)
+		p, ok := s.playlists[e.PlaylistId]
+		_, hasTrack := s.tracks[e.TrackId]
+		if !isCreate(req.UploadOperation) || req.UploaderId == "" || !ok || p.Deleted || !hasTrack {
+			eres.ResponseStatus.ResponseCode = mmudpb.ResponseStatus_SOFT_ERROR
+			continue
+		}
+		if old := s.findEntryByClientID(e.ClientId); old != nil {
+			eres.ResponseStatus.ResponseCode = mmudpb.ResponseStatus_ALREADY_EXISTS
+			eres.ServerId = old.Id
+			continue
+		}
+		ent := s.newEntry(e.PlaylistId, e.TrackId)
+		ent.ClientId = e.ClientId
+		eres.ResponseStatus.ResponseCode = mmudpb.ResponseStatus_OK
+		eres.ServerId = ent.Id
+	}
+	return res
+}
+
+// uploadSample accepts the samples in req, requesting an upload for
+// each track whose sample answers a challenge issued by uploadMetadata.
+// Like the official client's, the samples must be MP3.
+func (s *Server) uploadSample(req *mmuspb.UploadSampleRequest) *mmuspb.UploadSampleResponse {
+	res := new(mmuspb.UploadSampleResponse)
+	for _, spl := range req.TrackSample {
+		tres := &mmuspb.TrackSampleResponse{
+			ResponseCode: mmuspb.TrackSampleResponse_INVALID_SIGNATURE,
+		}
+		if spl.Track != nil {
+			tres.ClientTrackId = spl.Track.ClientId
+		}
+		var id string
+		if spl.SignedChallengeInfo != nil {
+			id = string(spl.SignedChallengeInfo.Signature)
+		}
+		if trk, ok := s.tracks[id]; ok && isPending(trk) && spl.SampleFormat != mmldpb.Track_MP3 {
+			tres.ResponseCode = mmuspb.TrackSampleResponse_PERMANENT_ERROR
+		} else if ok && isPending(trk) {
+			tres.ResponseCode = mmuspb.TrackSampleResponse_UPLOAD_REQUESTED
+			tres.ServerTrackId = trk.Id
+			if art := spl.UserAlbumArt; art != nil {
+				tres.AlbumArtUrl = s.setArt(trk, art)
+			}
+		}
+		res.TrackSampleResponse = append(res.TrackSampleResponse, tres)
+	}
+	return res
+}
+
+// clientState reports the quota and usage of the library.
+func (s *Server) clientState() *mmuspb.ClientStateResponse {
+	res := &mmuspb.ClientStateResponse{
+		LockerTrackLimit:   int64(s.TrackLimit),
+		TrackSizeLimitInMb: 300,
+	}
+	for _, trk := range s.tracks {
+		if trk.Deleted {
+			continue
+		}
+		res.TotalTrackCount++
+		if trk.UploaderId != "" {
+			res.UserSongsInLocker++
+		}
+	}
+	return res
+}
+
+// getJobs lists the tracks whose audio is still awaited.
+func (s *Server) getJobs() *mmuspb.GetJobsResponse {
+	res := &mmuspb.GetJobsResponse{GetTracksSuccess: true}
+	for _, trk := range s.sortedTracks(isPending) {
+		res.TracksToUpload = append(res.TracksToUpload, &mmudpb.TracksToUpload{
+			ClientId: trk.ClientId,
+			ServerId: trk.Id,
+			Status:   mmudpb.TracksToUpload_UPLOAD_REQUESTED,
+		})
+	}
+	return res
+}
+
+// handleExportIDs serves track listings.
+func (s *Server) handleExportIDs(w http.ResponseWriter, r *http.Request) {
+	buf, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := new(mmdspb.GetTracksToExportRequest)
+	if err := proto.Unmarshal(buf, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	start, err := parsePageToken(req.ContinuationToken)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	trks := s.sortedTracks(func(trk *track) bool {
+		if !isAvailable(trk) || trk.LastModifiedTimestamp <= req.UpdatedMin {
+			return false
+		}
+		if req.ExportType == mmdspb.GetTracksToExportRequest_PURCHASED_AND_PROMOTIONAL {
+			return trk.TrackType == mmldpb.Track_PURCHASED_TRACK ||
+				trk.TrackType == mmldpb.Track_PROMO_TRACK
+		}
+		return true
+	})
+	if len(trks) == 0 && req.UpdatedMin > 0 {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	res := &mmdspb.GetTracksToExportResponse{
+		Status:     mmdspb.GetTracksToExportResponse_OK,
+		UpdatedMin: req.UpdatedMin,
+	}
+	start, end, next := s.page(start, 0, len(trks))
+	res.ContinuationToken = next
+	for _, trk := range trks[start:end] {
+		info := new(mmddpb.DownloadTrackInfo)
+		convert.Convert(info, trk.Track)
+		info.TrackSize = int64(len(trk.audio))
+		res.DownloadTrackInfo = append(res.DownloadTrackInfo, info)
+		if trk.LastModifiedTimestamp > res.UpdatedMin {
+			res.UpdatedMin = trk.LastModifiedTimestamp
+		}
+	}
+	writeProto(w, res)
+}
+
+// handleExport serves download URLs for tracks.
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Device-ID") == "" {
+		http.Error(w, "missing device ID", http.StatusForbidden)
+		return
+	}
+	id := r.URL.Query().Get("songid")
+	s.mu.Lock()
+	trk, ok := s.tracks[id]
+	ok = ok && isAvailable(trk)
+	u := s.URL + "/download/" + url.PathEscape(id)
+	if ok && s.DownloadURLUses > 0 {
+		s.lastID++
+		tok := strconv.Itoa(s.lastID)
+		s.downloads[tok] = s.DownloadURLUses
+		u += "?token=" + tok
+	}
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"url": u})
+}
+
+// handleDownload serves the audio data of tracks, honouring Range
+// requests.
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/download/")
+	s.mu.Lock()
+	trk, ok := s.tracks[id]
+	ok = ok && isAvailable(trk)
+	var name string
+	var audio []byte
+	if ok {
+		name, audio = trk.Title+".mp3", trk.audio
+	}
+	expired := false
+	if tok := r.URL.Query().Get("token"); tok != "" {
+		uses, ok := s.downloads[tok]
+		expired = !ok || uses <= 0
+		if !expired {
+			s.downloads[tok] = uses - 1
+		}
+	}
+	limit := s.DownloadChunkLimit
+	s.mu.Unlock()
+	switch {
+	case !ok:
+		http.NotFound(w, r)
+		return
+	case expired:
+		http.Error(w, "download URL has expired", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(name))
+	if limit <= 0 {
+		http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(audio))
+		return
+	}
+	lw := &limitedResponseWriter{w, limit}
+	http.ServeContent(lw, r, name, time.Time{}, bytes.NewReader(audio))
+	if lw.n > 0 {
+		return
+	}
+	// Drop the connection to make the client see a truncated
+	// response.
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	panic(http.ErrAbortHandler)
+}
+
+// A limitedResponseWriter fails writes after n bytes have been written
+// to the response body.
+type limitedResponseWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *limitedResponseWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > w.n {
+		p = p[:w.n]
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.n -= int64(n)
+	if err == nil && w.n == 0 {
+		err = io.ErrShortWrite
+	}
+	return n, err
+}
+
+// setArt stores the album art given in art for trk and returns its URL.
+// Raw image data takes precedence over a URL.
+func (s *Server) setArt(trk *track, art *mmudpb.ImageUnion) string {
+	url := art.AlbumArtUrl
+	if len(art.UserAlbumArt) > 0 {
+		trk.art = art.UserAlbumArt
+		url = s.URL + "/art/" + trk.Id
+	}
+	if url != "" {
+		trk.AlbumArtRef = []*mmldpb.ImageRef{{
+			Store:  mmldpb.ImageRef_SHOEBOX,
+			Url:    url,
+			Origin: mmldpb.ImageRef_PERSONAL,
+		}}
+	}
+	return url
+}
+
+// handleArt serves album art uploaded with track samples.
+func (s *Server) handleArt(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/art/")
+	s.mu.Lock()
+	var art []byte
+	if trk, ok := s.tracks[id]; ok {
+		art = trk.art
+	}
+	s.mu.Unlock()
+	if art == nil {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(art))
+}
+
+// handleUploadSession opens upload sessions for imported tracks.
+func (s *Server) handleUploadSession(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		CreateSessionRequest struct {
+			Fields []struct {
+				Inlined *struct {
+					Name    string `json:"name"`
+					Content string `json:"content"`
+				} `json:"inlined"`
+			} `json:"fields"`
+		} `json:"createSessionRequest"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fields := make(map[string]string)
+	for _, f := range req.CreateSessionRequest.Fields {
+		if f.Inlined != nil {
+			fields[f.Inlined.Name] = f.Inlined.Content
+		}
+	}
+	id := fields["ServerId"]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	trk, ok := s.tracks[id]
+	if !ok || !isPending(trk) {
+		writeSessionError(w, http.StatusNotFound, "no upload requested for track "+id)
+		return
+	}
+	s.lastID++
+	sid := strconv.Itoa(s.lastID)
+	s.sessions[sid] = &session{id: id}
+	writeJSON(w, sessionStatus(sid, id, "OPEN", 0, 0, s.URL+"/upload/"+sid))
+}
+
+// handleUpload receives the audio data of tracks.  A request with a
+// Content-Range header of the form "bytes */total" queries the status
+// of the session; one of the form "bytes first-last/total" continues
+// the upload from byte first.  Without the header, the body is taken to
+// be the whole of the track.
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PUT" && r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sid := strings.TrimPrefix(r.URL.Path, "/upload/")
+	first, total, query, err := parseContentRange(r.Header.Get("Content-Range"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	sess, ok := s.sessions[sid]
+	ok = ok && s.tracks[sess.id] != nil
+	limit := s.UploadChunkLimit
+	s.mu.Unlock()
+	if !ok {
+		writeSessionError(w, http.StatusNotFound, "no such upload session")
+		return
+	}
+	putURL := s.URL + "/upload/" + sid
+	var body io.Reader = r.Body
+	if limit > 0 {
+		body = io.LimitReader(body, limit)
+	}
+	var buf []byte
+	if !query {
+		buf, err = ioutil.ReadAll(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	trk := s.tracks[sess.id]
+	switch {
+	case sess.done:
+		writeJSON(w, sessionStatus(sid, sess.id, "FINALIZED", int64(len(sess.audio)), int64(len(sess.audio)), ""))
+		return
+	case query:
+		writeJSON(w, sessionStatus(sid, sess.id, "OPEN", int64(len(sess.audio)), total, putURL))
+		return
+	case first != int64(len(sess.audio)):
+		writeSessionError(w, http.StatusBadRequest, "upload does not continue from the last received byte")
+		return
+	}
+	sess.audio = append(sess.audio, buf...)
+	if total < 0 {
+		total = r.ContentLength
+	}
+	if total < 0 {
+		total = int64(len(sess.audio))
+	}
+	if int64(len(sess.audio)) < total {
+		if limit > 0 && int64(len(buf)) == limit {
+			http.Error(w, "connection dropped", http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, sessionStatus(sid, sess.id, "OPEN", int64(len(sess.audio)), total, putURL))
+		return
+	}
+	sess.done = true
+	trk.audio = sess.audio
+	trk.EstimatedSize = int64(len(sess.audio))
+	trk.AvailabilityStatus = mmldpb.Track_AVAILABLE
+	trk.LastModifiedTimestamp = s.now()
+	writeJSON(w, sessionStatus(sid, sess.id, "FINALIZED", total, total, ""))
+}
+
+// parseContentRange parses the Content-Range header of an upload
+// request.  The first byte is 0 and the total -1 if the header is
+// empty.
+func parseContentRange(h string) (first, total int64, query bool, err error) {
+	if h == "" {
+		return 0, -1, false, nil
+	}
+	var rng string
+	if _, err = fmt.Sscanf(h, "bytes %s", &rng); err != nil {
+		return
+	}
+	i := strings.IndexByte(rng, '/')
+	if i < 0 {
+		return 0, 0, false, fmt.Errorf("invalid Content-Range %q", h)
+	}
+	if total, err = strconv.ParseInt(rng[i+1:], 10, 64); err != nil {
+		return
+	}
+	if rng[:i] == "*" {
+		return 0, total, true, nil
+	}
+	if j := strings.IndexByte(rng[:i], '-'); j >= 0 {
+		first, err = strconv.ParseInt(rng[:j], 10, 64)
+	} else {
+		err = fmt.Errorf("invalid Content-Range %q", h)
+	}
+	return
+}
+
+// isCreate reports whether op is the create operation.
+func isCreate(op *mmudpb.UploadOperation) bool {
+	return op != nil && op.Operation == mmudpb.UploadOperation_OPERATION_CREATE
+}
+
+// isAvailable reports whether trk can be listed and exported.
+func isAvailable(trk *track) bool {
+	return !trk.Deleted && trk.AvailabilityStatus == mmldpb.Track_AVAILABLE
+}
+
+// isPending reports whether the audio of trk is awaited.
+func isPending(trk *track) bool {
+	return !trk.Deleted && trk.AvailabilityStatus == mmldpb.Track_UPLOAD_REQUESTED
+}
+
+// sessionStatus returns the JSON representation of the status of an
+// upload session of the given track.
+func sessionStatus(sid, id, state string, transferred, total int64, putURL string) interface{} {
+	transfer := map[string]interface{}{
+		"name":             id,
+		"status":           "IN_PROGRESS",
+		"bytesTransferred": transferred,
+		"bytesTotal":       total,
+	}
+	status := map[string]interface{}{
+		"upload_id":              sid,
+		"state":                  state,
+		"externalFieldTransfers": []interface{}{transfer},
+	}
+	if putURL != "" {
+		transfer["putInfo"] = map[string]interface{}{"url": putURL}
+	} else {
+		transfer["status"] = "COMPLETED"
+		status["additionalInfo"] = map[string]interface{}{
+			"uploader_service.GoogleRupioAdditionalInfo": map[string]interface{}{
+				"completionInfo": map[string]interface{}{
+					"status": "SUCCESS",
+					"customerSpecificInfo": map[string]interface{}{
+						"ResponseCode":        http.StatusOK,
+						"ServerFileReference": id,
+					},
+				},
+			},
+		}
+	}
+	return map[string]interface{}{"sessionStatus": status}
+}
+
+// writeSessionError writes an upload session error with the given code
+// and message to w.
+func writeSessionError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, map[string]interface{}{
+		"errorMessage": map[string]interface{}{
+			"reason": "REQUEST_REJECTED",
+			"additionalInfo": map[string]interface{}{
+				"uploader_service.GoogleRupioAdditionalInfo": map[string]interface{}{
+					"completionInfo": map[string]interface{}{
+						"status": "REJECTED",
+						"customerSpecificInfo": map[string]interface{}{
+							"ResponseCode": code,
+						},
+					},
+					"requestRejectedInfo": map[string]interface{}{
+						"reasonDescription": msg,
+					},
+				},
+			},
+		},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeProto(w http.ResponseWriter, msg proto.Message) {
+	buf, err := proto.Marshal(msg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-google-protobuf")
+	w.Write(buf)
+}
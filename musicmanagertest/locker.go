@@ -0,0 +1,977 @@
+// This file implements the fake locker service.
+
+package musicmanagertest
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+
+	mmldpb "github.com/lxr/go.google.musicmanager/internal/locker_proto/data"
+	mmlspb "github.com/lxr/go.google.musicmanager/internal/locker_proto/service"
+)
+
+var errInvalidToken = errors.New("invalid continuation token")
+
+// handleLocker serves the protobuf calls under /locker/.
+func (s *Server) handleLocker(w http.ResponseWriter, r *http.Request) {
+	buf, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res proto.Message
+	switch endpoint := strings.TrimPrefix(r.URL.Path, "/locker/"); endpoint {
+	case "gettracks":
+		req := new(mmlspb.GetTracksRequest)
+		if err = proto.Unmarshal(buf, req); err == nil {
+			res, err = s.getTracks(req)
+		}
+	case "getplaylists":
+		req := new(mmlspb.GetPlaylistsRequest)
+		if err = proto.Unmarshal(buf, req); err == nil {
+			res, err = s.getPlaylists(req)
+		}
+	case "getplaylistentries":
+		req := new(mmlspb.GetPlaylistEntriesRequest)
+		if err = proto.Unmarshal(buf, req); err == nil {
+			res, err = s.getPlaylistEntries(req)
+		}
+	case "batchmutatetracks":
+		req := new(mmlspb.BatchMutateTracksRequest)
+		if err = proto.Unmarshal(buf, req); err == nil {
+			res = s.batchMutateTracks(req)
+		}
+	case "batchmutateplaylists":
+		req := new(mmlspb.BatchMutatePlaylistsRequest)
+		if err = proto.Unmarshal(buf, req); err == nil {
+			res = s.batchMutatePlaylists(req)
+		}
+	case "batchmutateplaylistentries":
+		req := new(mmlspb.BatchMutatePlaylistEntriesRequest)
+		if err = proto.Unmarshal(buf, req); err == nil {
+			res = s.batchMutatePlaylistEntries(req)
+		}
+	case "getalbums":
+		req := new(mmlspb.GetAlbumsRequest)
+		if err = proto.Unmarshal(buf, req); err == nil {
+			res = s.getAlbums(req)
+		}
+	case "getartists":
+		req := new(mmlspb.GetArtistsRequest)
+		if err = proto.Unmarshal(buf, req); err == nil {
+			res = s.getArtists(req)
+		}
+	case "getgenres":
+		req := new(mmlspb.GetGenresRequest)
+		if err = proto.Unmarshal(buf, req); err == nil {
+			res = s.getGenres(req)
+		}
+	case "getaggregationsbytracktype":
+		req := new(mmlspb.GetAggregationsByTrackTypeRequest)
+		if err = proto.Unmarshal(buf, req); err == nil {
+			res = s.trackTypeAggregations()
+		}
+	case "getaggregationsbyavailabilitystatus":
+		req := new(mmlspb.GetAggregationsByAvailabilityStatusRequest)
+		if err = proto.Unmarshal(buf, req); err == nil {
+			res = s.availabilityStatusAggregations()
+		}
+	case "getplaylistaggregations":
+		req := new(mmlspb.GetPlaylistAggregationsRequest)
+		if err = proto.Unmarshal(buf, req); err == nil {
+			res = s.playlistAggregations(req)
+		}
+	case "getdynamicplaylistentries":
+		req := new(mmlspb.GetDynamicPlaylistEntriesRequest)
+		if err = proto.Unmarshal(buf, req); err == nil {
+			res, err = s.getDynamicPlaylistEntries(req)
+		}
+	case "magicplaylist":
+		req := new(mmlspb.MagicPlaylistRequest)
+		if err = proto.Unmarshal(buf, req); err == nil {
+			res, err = s.magicPlaylist(req)
+		}
+	case "batchlookup":
+		req := new(mmlspb.BatchLookupRequest)
+		if err = proto.Unmarshal(buf, req); err == nil {
+			res = s.batchLookup(req)
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeProto(w, res)
+}
+
+// getTracks lists the tracks of the library matching req.
+func (s *Server) getTracks(req *mmlspb.GetTracksRequest) (*mmlspb.GetTracksResponse, error) {
+	start, err := parsePageToken(req.ContinuationToken)
+	if err != nil {
+		return nil, err
+	}
+	trks := s.sortedTracks(func(trk *track) bool {
+		if trk.LastModifiedTimestamp <= req.UpdatedMin {
+			return false
+		}
+		if trk.Deleted && !req.IncludeDeleted {
+			return false
+		}
+		for _, rs := range req.SearchRestriction {
+			if !matchRestriction(trk.Track, rs) {
+				return false
+			}
+		}
+		return req.RestrictionSet == nil || matchRestrictionSet(trk.Track, req.RestrictionSet)
+	})
+	if len(trks) == 0 && req.UpdatedMin > 0 {
+		return &mmlspb.GetTracksResponse{
+			ResponseCode: mmlspb.GetTracksResponse_NOT_MODIFIED,
+		}, nil
+	}
+	sortTracks(trks, req.SortOrder)
+	res := &mmlspb.GetTracksResponse{
+		ResponseCode:          mmlspb.GetTracksResponse_OK,
+		EstimatedTotalResults: int64(len(trks)),
+	}
+	start, end, next := s.page(start, int(req.MaxResults), len(trks))
+	res.ContinuationToken = next
+	for _, trk := range trks[start:end] {
+		t := proto.Clone(trk.Track).(*mmldpb.Track)
+		t.EstimatedSize = int64(len(trk.audio))
+		res.Track = append(res.Track, t)
+	}
+	return res, nil
+}
+
+// getPlaylists lists the playlists of the library matching req, from
+// least to most recently modified.
+func (s *Server) getPlaylists(req *mmlspb.GetPlaylistsRequest) (*mmlspb.GetPlaylistsResponse, error) {
+	start, err := parsePageToken(req.ContinuationToken)
+	if err != nil {
+		return nil, err
+	}
+	var ps []*mmldpb.Playlist
+	for _, p := range s.playlists {
+		if p.LastModifiedTimestamp > req.UpdatedMin && (!p.Deleted || req.IncludeDeleted) {
+			ps = append(ps, p)
+		}
+	}
+	if len(ps) == 0 && req.UpdatedMin > 0 {
+		return &mmlspb.GetPlaylistsResponse{
+			ResponseCode: mmlspb.GetPlaylistsResponse_NOT_MODIFIED,
+		}, nil
+	}
+	sort.Slice(ps, func(i, j int) bool {
+		return ps[i].LastModifiedTimestamp < ps[j].LastModifiedTimestamp
+	})
+	res := &mmlspb.GetPlaylistsResponse{
+		ResponseCode:          mmlspb.GetPlaylistsResponse_OK,
+		EstimatedTotalResults: int64(len(ps)),
+	}
+	start, end, next := s.page(start, int(req.MaxResults), len(ps))
+	res.ContinuationToken = next
+	for _, p := range ps[start:end] {
+		res.Playlist = append(res.Playlist, proto.Clone(p).(*mmldpb.Playlist))
+	}
+	return res, nil
+}
+
+// getPlaylistEntries lists the playlist entries of the library
+// matching req, ordered by playlist and position.
+func (s *Server) getPlaylistEntries(req *mmlspb.GetPlaylistEntriesRequest) (*mmlspb.GetPlaylistEntriesResponse, error) {
+	start, err := parsePageToken(req.ContinuationToken)
+	if err != nil {
+		return nil, err
+	}
+	var es []*mmldpb.PlaylistEntry
+	for _, e := range s.entries {
+		if req.PlaylistIdFilter != "" && e.PlaylistId != req.PlaylistIdFilter {
+			continue
+		}
+		if e.LastModifiedTimestamp <= req.UpdatedMin || (e.Deleted && !req.IncludeDeleted) {
+			continue
+		}
+		if req.OnlyShowAvailableTracks {
+			if trk, ok := s.tracks[e.TrackId]; !ok || !isAvailable(trk) {
+				continue
+			}
+		}
+		es = append(es, e)
+	}
+	if len(es) == 0 && req.UpdatedMin > 0 {
+		return &mmlspb.GetPlaylistEntriesResponse{
+			ResponseCode: mmlspb.GetPlaylistEntriesResponse_NOT_MODIFIED,
+		}, nil
+	}
+	sort.Slice(es, func(i, j int) bool {
+		if es[i].PlaylistId != es[j].PlaylistId {
+			return es[i].PlaylistId < es[j].PlaylistId
+		}
+		return es[i].AbsolutePosition < es[j].AbsolutePosition
+	})
+	res := &mmlspb.GetPlaylistEntriesResponse{
+		ResponseCode:          mmlspb.GetPlaylistEntriesResponse_OK,
+		EstimatedTotalResults: int64(len(es)),
+	}
+	start, end, next := s.page(start, int(req.MaxResults), len(es))
+	res.ContinuationToken = next
+	for _, e := range es[start:end] {
+		e = proto.Clone(e).(*mmldpb.PlaylistEntry)
+		if trk, ok := s.tracks[e.TrackId]; ok && req.IncludeAllTrackMetadata {
+			e.Track = proto.Clone(trk.Track).(*mmldpb.Track)
+			e.Track.EstimatedSize = int64(len(trk.audio))
+		}
+		res.PlaylistEntry = append(res.PlaylistEntry, e)
+	}
+	return res, nil
+}
+
+// batchMutateTracks applies the track mutations in req.  Creating
+// tracks is not supported; tracks are added with the upload service.
+func (s *Server) batchMutateTracks(req *mmlspb.BatchMutateTracksRequest) *mmlspb.BatchMutateTracksResponse {
+	res := &mmlspb.BatchMutateTracksResponse{
+		ResponseCode: []mmlspb.BatchMutateTracksResponse_BatchMutateTracksResponseCode{
+			mmlspb.BatchMutateTracksResponse_OK,
+		},
+	}
+	for _, m := range req.TrackMutation {
+		mres := s.mutateTrack(m, req.DetectTimestampConflict)
+		if mres.ResponseCode == mmlspb.MutateResponse_CONFLICT {
+			res.ResponseCode[0] = mmlspb.BatchMutateTracksResponse_CONFLICT
+		}
+		res.MutateResponse = append(res.MutateResponse, mres)
+	}
+	return res
+}
+
+// mutateTrack applies a single track mutation.
+func (s *Server) mutateTrack(m *mmlspb.MutateTrackRequest, detectConflict bool) *mmlspb.MutateResponse {
+	var id string
+	switch {
+	case m.UpdateTrack != nil:
+		id = m.UpdateTrack.Id
+	case m.DeleteTrack != "":
+		id = m.DeleteTrack
+	case m.UndeleteTrack != "":
+		id = m.UndeleteTrack
+	}
+	res := &mmlspb.MutateResponse{
+		ResponseCode: mmlspb.MutateResponse_INVALID_REQUEST,
+		Id:           id,
+	}
+	trk, ok := s.tracks[id]
+	if !ok {
+		return res
+	}
+	res.ClientId = trk.ClientId
+	switch {
+	case m.UpdateTrack != nil:
+		if trk.Deleted {
+			return res
+		}
+		if detectConflict && m.UpdateTrack.LastModifiedTimestamp != trk.LastModifiedTimestamp {
+			res.ResponseCode = mmlspb.MutateResponse_CONFLICT
+			return res
+		}
+		if m.PartialUpdate {
+			proto.Merge(trk.Track, m.UpdateTrack)
+		} else {
+			old := trk.Track
+			trk.Track = proto.Clone(m.UpdateTrack).(*mmldpb.Track)
+			trk.ClientId = old.ClientId
+			trk.CreationTimestamp = old.CreationTimestamp
+			trk.AvailabilityStatus = old.AvailabilityStatus
+			trk.UploaderId = old.UploaderId
+			trk.EstimatedSize = old.EstimatedSize
+		}
+		trk.Id = id
+		trk.Deleted = false
+	case m.DeleteTrack != "":
+		trk.Deleted = true
+	case m.UndeleteTrack != "":
+		trk.Deleted = false
+	}
+	trk.LastModifiedTimestamp = s.now()
+	res.ResponseCode = mmlspb.MutateResponse_OK
+	res.AvailabilityStatus = mmlspb.MutateResponse_AvailabilityStatus(trk.AvailabilityStatus)
+	return res
+}
+
+// batchMutatePlaylists applies the playlist mutations in req.
+func (s *Server) batchMutatePlaylists(req *mmlspb.BatchMutatePlaylistsRequest) *mmlspb.BatchMutatePlaylistsResponse {
+	res := &mmlspb.BatchMutatePlaylistsResponse{
+		ResponseCode: []mmlspb.BatchMutatePlaylistsResponse_BatchMutatePlaylistsResponseCode{
+			mmlspb.BatchMutatePlaylistsResponse_OK,
+		},
+	}
+	for _, m := range req.PlaylistMutation {
+		mres := s.mutatePlaylist(m, req.DetectTimestampConflict)
+		if mres.ResponseCode == mmlspb.MutateResponse_CONFLICT {
+			res.ResponseCode[0] = mmlspb.BatchMutatePlaylistsResponse_CONFLICT
+		}
+		res.MutateResponse = append(res.MutateResponse, mres)
+	}
+	return res
+}
+
+// mutatePlaylist applies a single playlist mutation.
+func (s *Server) mutatePlaylist(m *mmlspb.MutatePlaylistRequest, detectConflict bool) *mmlspb.MutateResponse {
+	res := &mmlspb.MutateResponse{ResponseCode: mmlspb.MutateResponse_INVALID_REQUEST}
+	if m.CreatePlaylist != nil {
+		p := s.newPlaylist()
+		p.ClientId = m.CreatePlaylist.ClientId
+		p.Name = m.CreatePlaylist.Name
+		p.PlaylistType = m.CreatePlaylist.PlaylistType
+		if p.PlaylistType == mmldpb.Playlist_DEFAULT {
+			p.PlaylistType = mmldpb.Playlist_USER_GENERATED
+		}
+		res.ResponseCode = mmlspb.MutateResponse_OK
+		res.Id, res.ClientId = p.Id, p.ClientId
+		return res
+	}
+	var id string
+	var ts int64
+	switch {
+	case m.UpdatePlaylist != nil:
+		id, ts = m.UpdatePlaylist.Id, m.UpdatePlaylist.LastModifiedTimestamp
+	case m.DeletePlaylist != "":
+		id = m.DeletePlaylist
+	case m.UndeletePlaylist != "":
+		id = m.UndeletePlaylist
+	}
+	res.Id = id
+	p, ok := s.playlists[id]
+	if !ok || p.Deleted == (m.UndeletePlaylist == "") {
+		return res
+	}
+	res.ClientId = p.ClientId
+	if detectConflict && m.UpdatePlaylist != nil && ts != p.LastModifiedTimestamp {
+		res.ResponseCode = mmlspb.MutateResponse_CONFLICT
+		return res
+	}
+	now := s.now()
+	switch {
+	case m.UpdatePlaylist != nil:
+		if m.PartialUpdate {
+			proto.Merge(p, m.UpdatePlaylist)
+		} else {
+			p.Name = m.UpdatePlaylist.Name
+			p.PlaylistType = m.UpdatePlaylist.PlaylistType
+		}
+		p.Id = id
+	case m.DeletePlaylist != "":
+		p.Deleted = true
+		for _, e := range s.playlistEntries(id) {
+			e.Deleted = true
+			e.LastModifiedTimestamp = now
+		}
+	case m.UndeletePlaylist != "":
+		p.Deleted = false
+	}
+	p.LastModifiedTimestamp = now
+	res.ResponseCode = mmlspb.MutateResponse_OK
+	return res
+}
+
+// batchMutatePlaylistEntries applies the playlist entry mutations in
+// req.
+func (s *Server) batchMutatePlaylistEntries(req *mmlspb.BatchMutatePlaylistEntriesRequest) *mmlspb.BatchMutatePlaylistEntriesResponse {
+	res := &mmlspb.BatchMutatePlaylistEntriesResponse{
+		ResponseCode: []mmlspb.BatchMutatePlaylistEntriesResponse_BatchMutatePlaylistEntriesResponseCode{
+			mmlspb.BatchMutatePlaylistEntriesResponse_OK,
+		},
+	}
+	for _, m := range req.PlaylistEntryMutation {
+		mres := s.mutatePlaylistEntry(m, req.DetectTimestampConflict)
+		if mres.ResponseCode == mmlspb.MutateResponse_CONFLICT {
+			res.ResponseCode[0] = mmlspb.BatchMutatePlaylistEntriesResponse_CONFLICT
+		}
+		res.MutateResponse = append(res.MutateResponse, mres)
+	}
+	return res
+}
+
+// mutatePlaylistEntry applies a single playlist entry mutation.
+func (s *Server) mutatePlaylistEntry(m *mmlspb.MutatePlaylistEntryRequest, detectConflict bool) *mmlspb.MutateResponse {
+	res := &mmlspb.MutateResponse{ResponseCode: mmlspb.MutateResponse_INVALID_REQUEST}
+	if c := m.CreatePlaylistEntry; c != nil {
+		p, ok := s.playlists[c.PlaylistId]
+		if _, hasTrack := s.tracks[c.TrackId]; !ok || p.Deleted || !hasTrack {
+			return res
+		}
+		e := s.newEntry(c.PlaylistId, c.TrackId)
+		e.ClientId = c.ClientId
+		if !s.placeEntry(e, c.PlaceAfterEntryId, c.PlaceBeforeEntryId) {
+			delete(s.entries, e.Id)
+			return res
+		}
+		res.ResponseCode = mmlspb.MutateResponse_OK
+		res.Id, res.ClientId = e.Id, e.ClientId
+		return res
+	}
+	var u *mmldpb.PlaylistEntry
+	var id string
+	switch {
+	case m.UpdatePlaylistEntry != nil:
+		u = m.UpdatePlaylistEntry
+		id = u.Id
+	case m.DeletePlaylistEntry != nil:
+		u = m.DeletePlaylistEntry
+		id = u.Id
+	case m.UndeletePlaylistEntry != "":
+		id = m.UndeletePlaylistEntry
+	}
+	res.Id = id
+	e, ok := s.entries[id]
+	if !ok || e.Deleted == (m.UndeletePlaylistEntry == "") {
+		return res
+	}
+	res.ClientId = e.ClientId
+	if detectConflict && u != nil && u.LastModifiedTimestamp != e.LastModifiedTimestamp {
+		res.ResponseCode = mmlspb.MutateResponse_CONFLICT
+		return res
+	}
+	switch {
+	case m.UpdatePlaylistEntry != nil:
+		if (u.PlaceAfterEntryId != "" || u.PlaceBeforeEntryId != "") &&
+			!s.placeEntry(e, u.PlaceAfterEntryId, u.PlaceBeforeEntryId) {
+			return res
+		}
+	case m.DeletePlaylistEntry != nil:
+		e.Deleted = true
+	case m.UndeletePlaylistEntry != "":
+		e.Deleted = false
+		s.placeEntry(e, "", "")
+	}
+	e.LastModifiedTimestamp = s.now()
+	res.ResponseCode = mmlspb.MutateResponse_OK
+	return res
+}
+
+// getAlbums lists the albums of the library.
+func (s *Server) getAlbums(req *mmlspb.GetAlbumsRequest) *mmlspb.GetAlbumsResponse {
+	order := req.SortOrder
+	if order == nil {
+		order = new(mmldpb.AlbumSortOrder)
+	}
+	albums := s.albums(func(*mmldpb.Track) bool { return true })
+	sort.SliceStable(albums, func(i, j int) bool {
+		a, b := albums[i], albums[j]
+		if order.Descending {
+			a, b = b, a
+		}
+		switch order.Attribute {
+		case mmldpb.AlbumSortOrder_LAST_PLAYED_TIME:
+			return a.LastTimePlayed < b.LastTimePlayed
+		case mmldpb.AlbumSortOrder_CREATION_TIME:
+			return a.CreationTimestamp < b.CreationTimestamp
+		}
+		return a.Name < b.Name
+	})
+	if n := int(req.MaxResults); n > 0 && n < len(albums) {
+		albums = albums[:n]
+	}
+	return &mmlspb.GetAlbumsResponse{Album: albums}
+}
+
+// getArtists lists the artists of the library by name.
+func (s *Server) getArtists(req *mmlspb.GetArtistsRequest) *mmlspb.GetArtistsResponse {
+	res := new(mmlspb.GetArtistsResponse)
+	artist := func(t *mmldpb.Track) string { return t.Artist }
+	for _, name := range s.trackValues(artist, req.SortOrder != nil && req.SortOrder.Descending) {
+		match := func(t *mmldpb.Track) bool { return t.Artist == name }
+		res.Artist = append(res.Artist, &mmldpb.Artist{
+			Name:            name,
+			TotalTrackCount: int32(s.countTracks(match)),
+			Album:           s.albums(match),
+		})
+	}
+	if n := int(req.MaxResults); n > 0 && n < len(res.Artist) {
+		res.Artist = res.Artist[:n]
+	}
+	return res
+}
+
+// getGenres lists the genres of the library by name.
+func (s *Server) getGenres(req *mmlspb.GetGenresRequest) *mmlspb.GetGenresResponse {
+	res := new(mmlspb.GetGenresResponse)
+	genre := func(t *mmldpb.Track) string { return t.Genre }
+	for _, name := range s.trackValues(genre, req.SortOrder != nil && req.SortOrder.Descending) {
+		match := func(t *mmldpb.Track) bool { return t.Genre == name }
+		res.Genre = append(res.Genre, &mmldpb.Genre{
+			Name:            name,
+			TotalTrackCount: int32(s.countTracks(match)),
+			Album:           s.albums(match),
+		})
+	}
+	if n := int(req.MaxResults); n > 0 && n < len(res.Genre) {
+		res.Genre = res.Genre[:n]
+	}
+	return res
+}
+
+// albums groups the undeleted tracks for which match returns true into
+// albums, sorted by name.
+func (s *Server) albums(match func(*mmldpb.Track) bool) []*mmldpb.Album {
+	type key struct{ name, artist string }
+	m := make(map[key]*mmldpb.Album)
+	var albums []*mmldpb.Album
+	for _, trk := range s.sortedTracks(func(trk *track) bool { return !trk.Deleted && match(trk.Track) }) {
+		k := key{trk.Album, trk.AlbumArtist}
+		a := m[k]
+		if a == nil {
+			a = &mmldpb.Album{
+				Name:              trk.Album,
+				AlbumArtist:       trk.AlbumArtist,
+				Artist:            trk.Artist,
+				AlbumMetajamId:    trk.AlbumMetajamId,
+				CreationTimestamp: trk.CreationTimestamp,
+			}
+			m[k] = a
+			albums = append(albums, a)
+		}
+		a.TrackCount++
+		a.IsCompilation = a.IsCompilation || trk.Compilation
+		if trk.RecentTimestamp > a.LastTimePlayed {
+			a.LastTimePlayed = trk.RecentTimestamp
+		}
+		if trk.CreationTimestamp < a.CreationTimestamp {
+			a.CreationTimestamp = trk.CreationTimestamp
+		}
+	}
+	sort.SliceStable(albums, func(i, j int) bool {
+		return albums[i].Name < albums[j].Name
+	})
+	return albums
+}
+
+// trackValues returns the distinct values of f over the undeleted
+// tracks of the library, sorted.
+func (s *Server) trackValues(f func(*mmldpb.Track) string, descending bool) []string {
+	seen := make(map[string]bool)
+	var vs []string
+	for _, trk := range s.tracks {
+		if v := f(trk.Track); !trk.Deleted && !seen[v] {
+			seen[v] = true
+			vs = append(vs, v)
+		}
+	}
+	sort.Strings(vs)
+	if descending {
+		sort.Sort(sort.Reverse(sort.StringSlice(vs)))
+	}
+	return vs
+}
+
+// countTracks returns the number of undeleted tracks for which match
+// returns true.
+func (s *Server) countTracks(match func(*mmldpb.Track) bool) int {
+	n := 0
+	for _, trk := range s.tracks {
+		if !trk.Deleted && match(trk.Track) {
+			n++
+		}
+	}
+	return n
+}
+
+// trackTypeAggregations counts the undeleted tracks of the library by
+// track type.
+func (s *Server) trackTypeAggregations() *mmlspb.GetAggregationsByTrackTypeResponse {
+	counts := make(map[mmldpb.Track_TrackType]int32)
+	for _, trk := range s.tracks {
+		if !trk.Deleted {
+			counts[trk.TrackType]++
+		}
+	}
+	res := new(mmlspb.GetAggregationsByTrackTypeResponse)
+	for t, n := range counts {
+		res.TrackTypeAggregate = append(res.TrackTypeAggregate, &mmldpb.TrackTypeAggregate{
+			TrackTypeValue: mmldpb.TrackTypeAggregate_TrackType(t),
+			Count:          n,
+		})
+	}
+	return res
+}
+
+// availabilityStatusAggregations counts the undeleted tracks of the
+// library by availability status.
+func (s *Server) availabilityStatusAggregations() *mmlspb.GetAggregationsByAvailabilityStatusResponse {
+	counts := make(map[mmldpb.Track_AvailabilityStatus]int32)
+	for _, trk := range s.tracks {
+		if !trk.Deleted {
+			counts[trk.AvailabilityStatus]++
+		}
+	}
+	res := new(mmlspb.GetAggregationsByAvailabilityStatusResponse)
+	for st, n := range counts {
+		res.AvailabilityStatusAggregate = append(res.AvailabilityStatusAggregate, &mmldpb.AvailabilityStatusAggregate{
+			AvailabilityStatus: mmldpb.AvailabilityStatusAggregate_AvailabilityStatus(st),
+			Count:              n,
+		})
+	}
+	return res
+}
+
+// playlistAggregations summarizes the undeleted playlists of the
+// library, from least to most recently modified.
+func (s *Server) playlistAggregations(req *mmlspb.GetPlaylistAggregationsRequest) *mmlspb.GetPlaylistAggregationsResponse {
+	var ps []*mmldpb.Playlist
+	for _, p := range s.playlists {
+		if !p.Deleted {
+			ps = append(ps, p)
+		}
+	}
+	sort.Slice(ps, func(i, j int) bool {
+		return ps[i].LastModifiedTimestamp < ps[j].LastModifiedTimestamp
+	})
+	if n := int(req.MaxResults); n > 0 && n < len(ps) {
+		ps = ps[:n]
+	}
+	res := new(mmlspb.GetPlaylistAggregationsResponse)
+	for _, p := range ps {
+		res.PlaylistAggregate = append(res.PlaylistAggregate, &mmldpb.PlaylistAggregate{
+			PlaylistId:     p.Id,
+			Name:           p.Name,
+			TrackCount:     int64(len(s.playlistEntries(p.Id))),
+			LastTimePlayed: p.RecentTimestamp,
+		})
+	}
+	return res
+}
+
+// getDynamicPlaylistEntries lists the entries of an automatically
+// generated playlist.  The entries are synthesized from the tracks of
+// the library; their IDs are those of the tracks.
+func (s *Server) getDynamicPlaylistEntries(req *mmlspb.GetDynamicPlaylistEntriesRequest) (*mmlspb.GetDynamicPlaylistEntriesResponse, error) {
+	start, err := parsePageToken(req.ContinuationToken)
+	if err != nil {
+		return nil, err
+	}
+	var keep func(*mmldpb.Track) bool
+	purchased := func(t *mmldpb.Track) bool { return t.TrackType == mmldpb.Track_PURCHASED_TRACK }
+	promoted := func(t *mmldpb.Track) bool { return t.TrackType == mmldpb.Track_PROMO_TRACK }
+	switch req.PlaylistEntriesType {
+	case mmlspb.GetDynamicPlaylistEntriesRequest_PURCHASED:
+		keep = purchased
+	case mmlspb.GetDynamicPlaylistEntriesRequest_THUMBS_UP:
+		keep = func(t *mmldpb.Track) bool { return t.Rating == mmldpb.Track_FIVE_STARS }
+	case mmlspb.GetDynamicPlaylistEntriesRequest_RECENTLY_ADDED:
+		keep = func(*mmldpb.Track) bool { return true }
+	case mmlspb.GetDynamicPlaylistEntriesRequest_PROMOTED:
+		keep = promoted
+	case mmlspb.GetDynamicPlaylistEntriesRequest_PROMOTED_AND_PURCHASED:
+		keep = func(t *mmldpb.Track) bool { return purchased(t) || promoted(t) }
+	default:
+		return &mmlspb.GetDynamicPlaylistEntriesResponse{
+			ResponseCode: mmlspb.GetDynamicPlaylistEntriesResponse_NOT_OK,
+		}, nil
+	}
+	trks := s.sortedTracks(func(trk *track) bool { return !trk.Deleted && keep(trk.Track) })
+	if req.PlaylistEntriesType == mmlspb.GetDynamicPlaylistEntriesRequest_RECENTLY_ADDED {
+		sort.SliceStable(trks, func(i, j int) bool {
+			return trks[i].CreationTimestamp > trks[j].CreationTimestamp
+		})
+	}
+	res := &mmlspb.GetDynamicPlaylistEntriesResponse{
+		ResponseCode:          mmlspb.GetDynamicPlaylistEntriesResponse_OK,
+		EstimatedTotalResults: int64(len(trks)),
+	}
+	start, end, next := s.page(start, int(req.MaxResults), len(trks))
+	res.ContinuationToken = next
+	for i, trk := range trks[start:end] {
+		e := &mmldpb.PlaylistEntry{
+			Id:                    trk.Id,
+			TrackId:               trk.Id,
+			AbsolutePosition:      int64(start + i),
+			CreationTimestamp:     trk.CreationTimestamp,
+			LastModifiedTimestamp: trk.LastModifiedTimestamp,
+		}
+		if req.IncludeAllTrackMetadata {
+			e.Track = proto.Clone(trk.Track).(*mmldpb.Track)
+			e.Track.EstimatedSize = int64(len(trk.audio))
+		}
+		res.PlaylistEntry = append(res.PlaylistEntry, e)
+	}
+	return res, nil
+}
+
+// magicPlaylist generates a playlist of the available tracks that
+// share an artist or album with the seeds of req, in order of
+// modification.
+func (s *Server) magicPlaylist(req *mmlspb.MagicPlaylistRequest) (*mmlspb.MagicPlaylistResponse, error) {
+	var artists, albums []string
+	for _, seed := range req.Seed {
+		switch seed.SeedType {
+		case mmldpb.MagicPlaylistSeed_TRACK:
+			trk, ok := s.tracks[seed.Seed]
+			if !ok {
+				return nil, errors.New("unknown seed track " + seed.Seed)
+			}
+			artists = append(artists, trk.Artist)
+		case mmldpb.MagicPlaylistSeed_ARTIST:
+			artists = append(artists, seed.Seed)
+		case mmldpb.MagicPlaylistSeed_ALBUM:
+			albums = append(albums, seed.Seed)
+		}
+	}
+	trks := s.sortedTracks(func(trk *track) bool {
+		if !isAvailable(trk) {
+			return false
+		}
+		for _, a := range artists {
+			if trk.Artist == a {
+				return true
+			}
+		}
+		for _, a := range albums {
+			if trk.Album == a {
+				return true
+			}
+		}
+		return false
+	})
+	n := int(req.NumRecommendations)
+	if n <= 0 {
+		n = 25
+	}
+	if n < len(trks) {
+		trks = trks[:n]
+	}
+	p, ok := s.playlists[req.PlaylistId]
+	if ok && !p.Deleted {
+		for _, e := range s.playlistEntries(p.Id) {
+			e.Deleted = true
+			e.LastModifiedTimestamp = s.now()
+		}
+		p.LastModifiedTimestamp = s.now()
+	} else {
+		p = s.newPlaylist()
+		p.PlaylistType = mmldpb.Playlist_MAGIC
+	}
+	if req.PlaylistName != "" {
+		p.Name = req.PlaylistName
+	}
+	res := &mmlspb.MagicPlaylistResponse{Playlist: proto.Clone(p).(*mmldpb.Playlist)}
+	for _, trk := range trks {
+		e := proto.Clone(s.newEntry(p.Id, trk.Id)).(*mmldpb.PlaylistEntry)
+		if req.IncludeAllTrackMetadata {
+			e.Track = proto.Clone(trk.Track).(*mmldpb.Track)
+			e.Track.EstimatedSize = int64(len(trk.audio))
+		}
+		res.PlaylistEntry = append(res.PlaylistEntry, e)
+	}
+	return res, nil
+}
+
+// batchLookup finds the items named in req.
+func (s *Server) batchLookup(req *mmlspb.BatchLookupRequest) *mmlspb.BatchLookupResponse {
+	res := new(mmlspb.BatchLookupResponse)
+	for _, l := range req.Track {
+		for _, trk := range s.tracks {
+			if lookupMatches(l.Id, l.ClientId, trk.Id, trk.ClientId) && (!trk.Deleted || req.IncludeDeleted) {
+				t := proto.Clone(trk.Track).(*mmldpb.Track)
+				t.EstimatedSize = int64(len(trk.audio))
+				res.Track = append(res.Track, t)
+				break
+			}
+		}
+	}
+	for _, l := range req.Playlist {
+		for _, p := range s.playlists {
+			if lookupMatches(l.Id, l.ClientId, p.Id, p.ClientId) && (!p.Deleted || req.IncludeDeleted) {
+				res.Playlist = append(res.Playlist, proto.Clone(p).(*mmldpb.Playlist))
+				break
+			}
+		}
+	}
+	for _, l := range req.PlaylistEntry {
+		for _, e := range s.entries {
+			if lookupMatches(l.Id, l.ClientId, e.Id, e.ClientId) && (!e.Deleted || req.IncludeDeleted) {
+				res.PlaylistEntry = append(res.PlaylistEntry, proto.Clone(e).(*mmldpb.PlaylistEntry))
+				break
+			}
+		}
+	}
+	return res
+}
+
+// lookupMatches reports whether a lookup by the given server or client
+// ID matches an item with the given IDs.
+func lookupMatches(id, clientID, itemID, itemClientID string) bool {
+	if id != "" {
+		return id == itemID
+	}
+	return clientID != "" && clientID == itemClientID
+}
+
+// parsePageToken parses a continuation token issued by page.
+func parsePageToken(token string) (int, error) {
+	if token == "" {
+		return 0, nil
+	}
+	start, err := strconv.Atoi(token)
+	if err != nil || start < 0 {
+		return 0, errInvalidToken
+	}
+	return start, nil
+}
+
+// page returns the bounds of the page of a listing of n items starting
+// at start, and the continuation token of the next page, if any.  The
+// page holds at most max items, or s.PageSize if max is not positive.
+func (s *Server) page(start, max, n int) (int, int, string) {
+	if max <= 0 || max > s.PageSize {
+		max = s.PageSize
+	}
+	if start > n {
+		start = n
+	}
+	end := start + max
+	if end >= n {
+		return start, n, ""
+	}
+	return start, end, strconv.Itoa(end)
+}
+
+// matchRestrictionSet reports whether t matches rs.
+func matchRestrictionSet(t *mmldpb.Track, rs *mmldpb.TrackSearchRestrictionSet) bool {
+	or := rs.Type == mmldpb.TrackSearchRestrictionSet_OR
+	for _, r := range rs.Restriction {
+		if matchRestriction(t, r) == or {
+			return or
+		}
+	}
+	for _, sub := range rs.SubSet {
+		if matchRestrictionSet(t, sub) == or {
+			return or
+		}
+	}
+	return !or
+}
+
+// matchRestriction reports whether t matches r.  Values that parse as
+// integers are compared numerically, others lexically.
+func matchRestriction(t *mmldpb.Track, r *mmldpb.TrackSearchRestriction) bool {
+	v := searchAttribute(t, r.Attribute)
+	if r.ComparisonType == mmldpb.TrackSearchRestriction_PARTIAL_MATCH {
+		return strings.Contains(strings.ToLower(v), strings.ToLower(r.Value))
+	}
+	c := compareValues(v, r.Value)
+	switch r.ComparisonType {
+	case mmldpb.TrackSearchRestriction_EQUAL:
+		return c == 0
+	case mmldpb.TrackSearchRestriction_NOT_EQUAL:
+		return c != 0
+	case mmldpb.TrackSearchRestriction_GREATER_THAN:
+		return c > 0
+	case mmldpb.TrackSearchRestriction_GREATER_EQUAL:
+		return c >= 0
+	case mmldpb.TrackSearchRestriction_LESS_THAN:
+		return c < 0
+	case mmldpb.TrackSearchRestriction_LESS_EQUAL:
+		return c <= 0
+	}
+	return false
+}
+
+func compareValues(a, b string) int {
+	x, errx := strconv.ParseInt(a, 10, 64)
+	y, erry := strconv.ParseInt(b, 10, 64)
+	switch {
+	case errx != nil || erry != nil:
+		return strings.Compare(a, b)
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// searchAttribute returns the value of the given attribute of t as a
+// string.
+func searchAttribute(t *mmldpb.Track, attr mmldpb.TrackSearchRestriction_TrackAttribute) string {
+	switch attr {
+	case mmldpb.TrackSearchRestriction_TITLE:
+		return t.Title
+	case mmldpb.TrackSearchRestriction_ARTIST:
+		return t.Artist
+	case mmldpb.TrackSearchRestriction_ALBUM:
+		return t.Album
+	case mmldpb.TrackSearchRestriction_ALBUM_ARTIST:
+		return t.AlbumArtist
+	case mmldpb.TrackSearchRestriction_GENRE:
+		return t.Genre
+	case mmldpb.TrackSearchRestriction_AVAILABILITY_STATUS:
+		return strconv.Itoa(int(t.AvailabilityStatus))
+	case mmldpb.TrackSearchRestriction_TRACK_TYPE:
+		return strconv.Itoa(int(t.TrackType))
+	case mmldpb.TrackSearchRestriction_YEAR:
+		return strconv.Itoa(int(t.Year))
+	case mmldpb.TrackSearchRestriction_STORE_ID:
+		return t.StoreId
+	case mmldpb.TrackSearchRestriction_ALBUM_METAJAM_ID:
+		return t.AlbumMetajamId
+	}
+	return ""
+}
+
+// sortTracks stably sorts trks by the given sort orders.
+func sortTracks(trks []*track, orders []*mmldpb.TrackSortOrder) {
+	sort.SliceStable(trks, func(i, j int) bool {
+		for _, o := range orders {
+			c := compareTracks(trks[i].Track, trks[j].Track, o.Attribute)
+			if o.Descending {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+}
+
+func compareTracks(a, b *mmldpb.Track, attr mmldpb.TrackSortOrder_TrackAttribute) int {
+	var x, y int64
+	switch attr {
+	case mmldpb.TrackSortOrder_ARTIST:
+		return strings.Compare(a.Artist, b.Artist)
+	case mmldpb.TrackSortOrder_ALBUM:
+		return strings.Compare(a.Album, b.Album)
+	case mmldpb.TrackSortOrder_TITLE:
+		return strings.Compare(a.Title, b.Title)
+	case mmldpb.TrackSortOrder_LAST_MODIFIED_TIME:
+		x, y = a.LastModifiedTimestamp, b.LastModifiedTimestamp
+	case mmldpb.TrackSortOrder_TRACK_NUMBER:
+		x, y = int64(a.TrackNumber), int64(b.TrackNumber)
+	case mmldpb.TrackSortOrder_PLAY_COUNT:
+		x, y = int64(a.PlayCount), int64(b.PlayCount)
+	case mmldpb.TrackSortOrder_DURATION_MILLIS:
+		x, y = a.DurationMillis, b.DurationMillis
+	case mmldpb.TrackSortOrder_RATING:
+		x, y = int64(a.Rating), int64(b.Rating)
+	case mmldpb.TrackSortOrder_CREATION_TIME:
+		x, y = a.CreationTimestamp, b.CreationTimestamp
+	}
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
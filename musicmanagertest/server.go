@@ -0,0 +1,375 @@
+// Package musicmanagertest implements a fake Music Manager server for
+// testing code that uses package musicmanager.
+package musicmanagertest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lxr/go.google.musicmanager"
+	convert "github.com/lxr/go.google.musicmanager/internal/convert"
+	mmldpb "github.com/lxr/go.google.musicmanager/internal/locker_proto/data"
+)
+
+// A Server is an HTTP server speaking the Music Manager protocol.  It
+// keeps the user's library in memory, so that tracks imported and
+// uploaded through it can be listed and exported again.
+//
+// The configuration fields of a Server may be changed while it is
+// running, but not concurrently with requests to it.
+type Server struct {
+	*httptest.Server
+
+	// PageSize is the maximum number of tracks in a page of a track
+	// listing.  NewServer sets it to 1000.
+	PageSize int
+
+	// TrackLimit is the maximum number of tracks the library can
+	// hold.  NewServer sets it to 50000.
+	TrackLimit int
+
+	// If RequestSamples is true, the server asks for an audio sample
+	// of every imported track before requesting its upload.
+	RequestSamples bool
+
+	// If UploadChunkLimit is positive, the server stores at most
+	// that many bytes of audio data per upload request and then
+	// fails the request, as if the connection had dropped.  The
+	// upload can be resumed from where it stopped.
+	UploadChunkLimit int64
+
+	// If DownloadURLUses is positive, a download URL returned by
+	// the export service expires after serving that many requests.
+	DownloadURLUses int
+
+	// If DownloadChunkLimit is positive, the server sends at most
+	// that many bytes of audio data per download request and then
+	// drops the connection.
+	DownloadChunkLimit int64
+
+	// If Policy is non-nil, it is sent to the client in every
+	// response of the upload service.
+	Policy *musicmanager.ClientPolicy
+
+	mu          sync.Mutex
+	tracks      map[string]*track // by server ID
+	playlists   map[string]*mmldpb.Playlist
+	entries     map[string]*mmldpb.PlaylistEntry
+	sessions    map[string]*session // by upload session ID
+	downloads   map[string]int      // download URL token to uses left
+	devices     map[string]string   // device ID to name
+	uploadState musicmanager.UploadState
+	lastID      int
+	clock       int64
+}
+
+// A track is a track in the library of a Server.
+type track struct {
+	*mmldpb.Track
+	audio []byte
+	art   []byte
+}
+
+// A session is an upload session of a track.
+type session struct {
+	id    string // server ID of the track
+	audio []byte // received so far
+	done  bool
+}
+
+// NewServer starts and returns a new Server with an empty library.
+// The caller should call Close when finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{
+		PageSize:   1000,
+		TrackLimit: 50000,
+		tracks:     make(map[string]*track),
+		playlists:  make(map[string]*mmldpb.Playlist),
+		entries:    make(map[string]*mmldpb.PlaylistEntry),
+		sessions:   make(map[string]*session),
+		downloads:  make(map[string]int),
+		devices:    make(map[string]string),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/upsj/", s.handleUploadService)
+	mux.HandleFunc("/music/exportids", s.handleExportIDs)
+	mux.HandleFunc("/music/export", s.handleExport)
+	mux.HandleFunc("/download/", s.handleDownload)
+	mux.HandleFunc("/uploadsj/scottyagent", s.handleUploadSession)
+	mux.HandleFunc("/upload/", s.handleUpload)
+	mux.HandleFunc("/locker/", s.handleLocker)
+	mux.HandleFunc("/art/", s.handleArt)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Endpoints returns the endpoints of the services provided by s.
+func (s *Server) Endpoints() musicmanager.Endpoints {
+	return musicmanager.Endpoints{
+		UploadService: s.URL + "/upsj/",
+		ExportIDs:     s.URL + "/music/exportids",
+		Export:        s.URL + "/music/export",
+		UploadSession: s.URL + "/uploadsj/scottyagent",
+		Locker:        s.URL + "/locker/",
+	}
+}
+
+// NewClient returns a new Music Manager client with the given device ID
+// talking to s.
+func (s *Server) NewClient(deviceID string) (*musicmanager.Client, error) {
+	client, err := musicmanager.NewClient(s.Client(), deviceID)
+	if err != nil {
+		return nil, err
+	}
+	client.Endpoints = s.Endpoints()
+	return client, nil
+}
+
+// AddTrack adds a track with the given metadata and audio data directly
+// to the library of s and returns its server ID.  The Id, timestamp,
+// Deleted and AvailabilityStatus fields of t are ignored.
+func (s *Server) AddTrack(t *musicmanager.Track, audio []byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	trk := s.newTrack()
+	id, created := trk.Id, trk.CreationTimestamp
+	convert.Convert(trk.Track, t)
+	trk.Id = id
+	trk.CreationTimestamp = created
+	trk.LastModifiedTimestamp = created
+	trk.RecentTimestamp = 0
+	trk.Deleted = false
+	trk.AvailabilityStatus = mmldpb.Track_AVAILABLE
+	trk.audio = audio
+	trk.EstimatedSize = int64(len(audio))
+	return id
+}
+
+// Track returns the metadata and audio data of the track with the given
+// server ID.  The audio data is nil if the track has not been uploaded
+// yet.  The boolean result reports whether such a track exists.
+func (s *Server) Track(id string) (*musicmanager.Track, []byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	trk, ok := s.tracks[id]
+	if !ok {
+		return nil, nil, false
+	}
+	t := new(musicmanager.Track)
+	convert.Convert(t, trk.Track)
+	t.TrackSize = int64(len(trk.audio))
+	return t, trk.audio, true
+}
+
+// AddPlaylist adds a playlist with the given name and tracks directly
+// to the library of s and returns its ID.
+func (s *Server) AddPlaylist(name string, trackIDs ...string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.newPlaylist()
+	p.Name = name
+	p.PlaylistType = mmldpb.Playlist_USER_GENERATED
+	for _, id := range trackIDs {
+		s.newEntry(p.Id, id)
+	}
+	return p.Id
+}
+
+// Devices returns a map from the IDs of the devices registered with s
+// to their names.
+func (s *Server) Devices() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := make(map[string]string)
+	for id, name := range s.devices {
+		m[id] = name
+	}
+	return m
+}
+
+// UploadState returns the upload state most recently declared by a
+// client, or 0 if none has been declared.
+func (s *Server) UploadState() musicmanager.UploadState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.uploadState
+}
+
+// newTrack adds a new, empty track to the library and returns it.  It
+// must be called with s.mu held.
+func (s *Server) newTrack() *track {
+	now := s.now()
+	trk := &track{Track: &mmldpb.Track{
+		Id:                    s.newID(),
+		CreationTimestamp:     now,
+		LastModifiedTimestamp: now,
+	}}
+	s.tracks[trk.Id] = trk
+	return trk
+}
+
+// newID returns a new unique ID.  It must be called with s.mu held.
+func (s *Server) newID() string {
+	s.lastID++
+	return fmt.Sprintf("00000000-0000-3000-8000-%012x", s.lastID)
+}
+
+// newPlaylist adds a new, empty playlist to the library and returns it.
+// It must be called with s.mu held.
+func (s *Server) newPlaylist() *mmldpb.Playlist {
+	now := s.now()
+	p := &mmldpb.Playlist{
+		Id:                    s.newID(),
+		CreationTimestamp:     now,
+		LastModifiedTimestamp: now,
+	}
+	s.playlists[p.Id] = p
+	return p
+}
+
+// newEntry appends a new entry for the given track to the given
+// playlist and returns it.  It must be called with s.mu held.
+func (s *Server) newEntry(playlistID, trackID string) *mmldpb.PlaylistEntry {
+	now := s.now()
+	e := &mmldpb.PlaylistEntry{
+		Id:                    s.newID(),
+		PlaylistId:            playlistID,
+		TrackId:               trackID,
+		AbsolutePosition:      int64(len(s.playlistEntries(playlistID))),
+		CreationTimestamp:     now,
+		LastModifiedTimestamp: now,
+	}
+	s.entries[e.Id] = e
+	return e
+}
+
+// playlistEntries returns the undeleted entries of the given playlist
+// in order.  It must be called with s.mu held.
+func (s *Server) playlistEntries(playlistID string) []*mmldpb.PlaylistEntry {
+	var es []*mmldpb.PlaylistEntry
+	for _, e := range s.entries {
+		if e.PlaylistId == playlistID && !e.Deleted {
+			es = append(es, e)
+		}
+	}
+	sort.Slice(es, func(i, j int) bool {
+		return es[i].AbsolutePosition < es[j].AbsolutePosition
+	})
+	return es
+}
+
+// placeEntry moves e to just after the entry with the ID after, or
+// just before the one with the ID before, or to the end of its
+// playlist if neither is given, and renumbers the entries of the
+// playlist.  It reports whether the given neighbours exist in the
+// playlist.  It must be called with s.mu held.
+func (s *Server) placeEntry(e *mmldpb.PlaylistEntry, after, before string) bool {
+	var es []*mmldpb.PlaylistEntry
+	for _, o := range s.playlistEntries(e.PlaylistId) {
+		if o != e {
+			es = append(es, o)
+		}
+	}
+	i := len(es)
+	if after != "" || before != "" {
+		i = -1
+		for j, o := range es {
+			switch o.Id {
+			case after:
+				i = j + 1
+			case before:
+				if after == "" {
+					i = j
+				}
+			}
+		}
+		if i < 0 {
+			return false
+		}
+	}
+	es = append(es[:i], append([]*mmldpb.PlaylistEntry{e}, es[i:]...)...)
+	for j, o := range es {
+		if o.AbsolutePosition != int64(j) {
+			o.AbsolutePosition = int64(j)
+			o.LastModifiedTimestamp = s.now()
+		}
+	}
+	return true
+}
+
+// now returns the current time as a Unix timestamp in microseconds.
+// The returned timestamps are strictly increasing, so that every
+// modification of the library can be told apart by its timestamp.  It
+// must be called with s.mu held.
+func (s *Server) now() int64 {
+	now := time.Now().UnixNano() / 1000
+	if now <= s.clock {
+		now = s.clock + 1
+	}
+	s.clock = now
+	return now
+}
+
+// findByClientID returns the undeleted track with the given client ID,
+// or nil if there is none.  It must be called with s.mu held.
+func (s *Server) findByClientID(clientID string) *track {
+	if clientID == "" {
+		return nil
+	}
+	for _, trk := range s.tracks {
+		if trk.ClientId == clientID && !trk.Deleted {
+			return trk
+		}
+	}
+	return nil
+}
+
+// findPlaylistByClientID returns the undeleted playlist with the given
+// client ID, or nil if there is none.  It must be called with s.mu
+// held.
+func (s *Server) findPlaylistByClientID(clientID string) *mmldpb.Playlist {
+	if clientID == "" {
+		return nil
+	}
+	for _, p := range s.playlists {
+		if p.ClientId == clientID && !p.Deleted {
+			return p
+		}
+	}
+	return nil
+}
+
+// findEntryByClientID returns the undeleted playlist entry with the
+// given client ID, or nil if there is none.  It must be called with
+// s.mu held.
+func (s *Server) findEntryByClientID(clientID string) *mmldpb.PlaylistEntry {
+	if clientID == "" {
+		return nil
+	}
+	for _, e := range s.entries {
+		if e.ClientId == clientID && !e.Deleted {
+			return e
+		}
+	}
+	return nil
+}
+
+// sortedTracks returns the tracks of the library for which keep returns
+// true, ordered from least to most recently modified.  It must be
+// called with s.mu held.
+func (s *Server) sortedTracks(keep func(*track) bool) []*track {
+	trks := make([]*track, 0, len(s.tracks))
+	for _, trk := range s.tracks {
+		if keep(trk) {
+			trks = append(trks, trk)
+		}
+	}
+	sort.Slice(trks, func(i, j int) bool {
+		return trks[i].LastModifiedTimestamp < trks[j].LastModifiedTimestamp
+	})
+	return trks
+}
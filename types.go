@@ -5,8 +5,13 @@ package musicmanager
 
 import (
 	"fmt"
+	"io"
+	"time"
 
 	mmdspb "github.com/lxr/go.google.musicmanager/internal/download_proto/service"
+	mmldpb "github.com/lxr/go.google.musicmanager/internal/locker_proto/data"
+	mmlspb "github.com/lxr/go.google.musicmanager/internal/locker_proto/service"
+	mmudpb "github.com/lxr/go.google.musicmanager/internal/upload_proto/data"
 	mmuspb "github.com/lxr/go.google.musicmanager/internal/upload_proto/service"
 )
 
@@ -44,6 +49,78 @@ func (e ListError) Error() string {
 	return fmt.Sprint("musicmanager list error: ", mmdspb.GetTracksToExportResponse_TracksToExportStatus(e))
 }
 
+// A PlaylistImportError is returned by Client.ImportPlaylists and
+// Client.ImportPlaylistEntries if the server rejects a playlist or an
+// entry.
+type PlaylistImportError int32
+
+const (
+	ErrPlaylistImportUnknown    PlaylistImportError = 0
+	ErrPlaylistAlreadyExists    PlaylistImportError = 2
+	ErrPlaylistSoftError        PlaylistImportError = 3
+	ErrPlaylistMetadataTooLarge PlaylistImportError = 4
+)
+
+func (e PlaylistImportError) Error() string {
+	return fmt.Sprint("musicmanager playlist import error: ", mmudpb.ResponseStatus_ResponseCode(e))
+}
+
+// A LockerError is returned by the listing calls of the locker
+// service, such as Client.QueryTracks, if the server refuses to list
+// the items for some reason.
+type LockerError int32
+
+const (
+	ErrLockerUnknown LockerError = 0
+
+	// ErrGone means that the listing can no longer be continued
+	// from the given timestamp or page token, and has to be
+	// restarted from the beginning.
+	ErrGone LockerError = 3
+)
+
+func (e LockerError) Error() string {
+	return fmt.Sprint("musicmanager locker error: ", mmlspb.GetTracksResponse_ResponseCode(e))
+}
+
+// A DynamicPlaylistError is returned by
+// Client.ListDynamicPlaylistEntries if the server refuses to list the
+// entries of a playlist.
+type DynamicPlaylistError int32
+
+const (
+	ErrDynamicPlaylistUnknown DynamicPlaylistError = 0
+
+	// ErrDynamicPlaylistNotOK is all the server says when it fails
+	// to list a playlist, for example one of an unknown type.
+	ErrDynamicPlaylistNotOK DynamicPlaylistError = 2
+)
+
+func (e DynamicPlaylistError) Error() string {
+	return fmt.Sprint("musicmanager dynamic playlist error: ", mmlspb.GetDynamicPlaylistEntriesResponse_ResponseCode(e))
+}
+
+// A MutateError is returned by the methods that modify items in the
+// locker, such as Client.UpdateTracks, if the server refuses to make a
+// modification.
+type MutateError int32
+
+const (
+	ErrMutateUnknown MutateError = 0
+
+	// ErrConflict means that the item has been modified on the
+	// server after the last modification time given for it.  See
+	// Client.DetectConflicts.
+	ErrConflict MutateError = 2
+
+	ErrInvalidMutation  MutateError = 3
+	ErrMetadataTooLarge MutateError = 4
+)
+
+func (e MutateError) Error() string {
+	return fmt.Sprint("musicmanager mutate error: ", mmlspb.MutateResponse_MutateResponseCode(e))
+}
+
 // An ImportError is returned by Client.ImportTracks if the server
 // rejects a track based on its metadata or audio sample.
 type ImportError int32
@@ -65,6 +142,107 @@ func (e ImportError) Error() string {
 	return fmt.Sprint("musicmanager import error: ", mmuspb.TrackSampleResponse_ResponseCode(e))
 }
 
+// A ClientState describes the quota and usage of a user's Play Music
+// library.
+type ClientState struct {
+	// The maximum number of tracks the library can hold.
+	LockerTrackLimit int64
+
+	// The number of tracks the user has uploaded to the library.
+	UserSongsInLocker int64
+
+	// The maximum size of a single track in megabytes.
+	TrackSizeLimitInMb int
+
+	// The total number of tracks in the library, including
+	// purchased and promotional ones.
+	TotalTrackCount int64
+}
+
+// Remaining returns the number of tracks that can still be imported
+// before the library's track limit is reached.
+func (s *ClientState) Remaining() int64 {
+	if n := s.LockerTrackLimit - s.UserSongsInLocker; n > 0 {
+		return n
+	}
+	return 0
+}
+
+// A BackoffError is returned by Client.ImportTracks if the server has
+// asked the client to pause or abort its uploads.
+type BackoffError struct {
+	// Abort is true if the server asked the client to abort its
+	// uploads altogether rather than just pause them.
+	Abort bool
+
+	// RetryIn is how long the client should wait before trying
+	// again, or 0 if the server did not say.
+	RetryIn time.Duration
+}
+
+func (e *BackoffError) Error() string {
+	action := "pause"
+	if e.Abort {
+		action = "abort"
+	}
+	if e.RetryIn > 0 {
+		return fmt.Sprintf("musicmanager: server requested to %s uploads, retry in %v", action, e.RetryIn)
+	}
+	return fmt.Sprintf("musicmanager: server requested to %s uploads", action)
+}
+
+// A ClientPolicy describes how the server wants the client to behave.
+// Bandwidth caps of 0 mean no cap.
+type ClientPolicy struct {
+	PauseUploads             bool
+	Abort                    bool
+	RetryInMinutes           int
+	BandwidthCapKbps         int
+	PauseDownloads           bool
+	DownloadBandwidthCapKbps int
+
+	// The time the policy was received from the server.
+	Received time.Time `convert:"-"`
+}
+
+// RetryAt returns the time after which the client may resume
+// operations paused or aborted by the policy.
+func (p *ClientPolicy) RetryAt() time.Time {
+	return p.Received.Add(time.Duration(p.RetryInMinutes) * time.Minute)
+}
+
+// JobStatus describes why the server is requesting a track upload.
+type JobStatus int
+
+const (
+	ForceReupload JobStatus = 3 + iota
+	UploadRequested
+)
+
+func (s JobStatus) String() string {
+	return mmudpb.TracksToUpload_TrackStatus(s).String()
+}
+
+// A Job is a track the server is waiting for the client to upload.
+type Job struct {
+	ClientId string
+	ServerId string
+	Status   JobStatus
+}
+
+// UploadState represents the state of the client's upload session.
+type UploadState int
+
+const (
+	UploadStarted UploadState = 1 + iota
+	UploadPaused
+	UploadStopped
+)
+
+func (s UploadState) String() string {
+	return mmuspb.UpdateUploadStateRequest_UploadState(s).String()
+}
+
 // TrackChannels represents the number of channels a Track can have.
 type TrackChannels int
 
@@ -98,8 +276,71 @@ const (
 	Promotional
 )
 
-// A Track represents metadata about a track.  When in a TrackList,
-// only a subset of the fields are populated.
+func (t TrackType) String() string {
+	return mmldpb.Track_TrackType(t).String()
+}
+
+// ContentType represents the audio format of a track.  The zero value
+// is taken to mean MP3.
+type ContentType int
+
+const (
+	MP3 ContentType = 1 + iota
+	M4A
+	AAC
+	FLAC
+	OGG
+	WMA
+	M4P // protected AAC; cannot be imported
+	ALAC
+)
+
+func (t ContentType) String() string {
+	return mmldpb.Track_ContentType(t).String()
+}
+
+// MIMEType returns the MIME type with which audio data of type t should
+// be uploaded, or the empty string if tracks of type t cannot be
+// imported.
+func (t ContentType) MIMEType() string {
+	switch t {
+	case 0, MP3:
+		return "audio/mpeg"
+	case M4A, ALAC:
+		return "audio/mp4"
+	case AAC:
+		return "audio/aac"
+	case FLAC:
+		return "audio/flac"
+	case OGG:
+		return "audio/ogg"
+	case WMA:
+		return "audio/x-ms-wma"
+	default:
+		return ""
+	}
+}
+
+// AvailabilityStatus describes the state of a track's audio on the
+// server.
+type AvailabilityStatus int
+
+const (
+	StatusPending AvailabilityStatus = 1 + iota
+	StatusMatched
+	StatusUploadRequested
+	StatusAvailable
+	StatusForceReupload
+	StatusUploadPermanentlyFailed
+)
+
+func (s AvailabilityStatus) String() string {
+	return mmldpb.Track_AvailabilityStatus(s).String()
+}
+
+// A Track represents metadata about a track.  When in a TrackList
+// returned by Client.ListTracks, only a subset of the fields are
+// populated.
 type Track struct {
 	// There fields are present inside a TrackList.
 	Id          string
@@ -124,17 +365,39 @@ type Track struct {
 	Channels        TrackChannels
 	Rating          TrackRating
 	TrackType       TrackType
-	// BUG(lor): Album art cannot be uploaded.
+	ContentType     ContentType
+	// BUG(lor): Album art can only be uploaded with an audio sample
+	// of the track.  If the server accepts the metadata of a track
+	// without asking for a sample, its AlbumArt and AlbumArtUrl are
+	// silently dropped.
+
+	// Album art for the track, either as raw image data or as the
+	// URL of an image.  It is only sent to the server on import if
+	// the server asks for an audio sample of the track, after which
+	// AlbumArtUrl is set to the URL of the art as stored on the
+	// server.  Client.QueryTracks also populates AlbumArtUrl.
+	AlbumArt    []byte
+	AlbumArtUrl string
 
 	// BitRate is the bitrate of the track in kbps, or 0 if don't
 	// care.
 	BitRate int
 
 	// SampleFunc can be optionally used to provide the server with
-	// a 128kbps MP3 sample of the track if requested.  It takes the
-	// start and length of the desired sample in milliseconds.  If
-	// SampleFunc is nil, an empty sample is sent.
+	// a 128kbps MP3 sample of the track if requested, whatever the
+	// ContentType of the track.  It takes the start and length of
+	// the desired sample in milliseconds.  If SampleFunc is nil, an
+	// empty sample is sent, which the server may reject.
 	SampleFunc func(start, duration int) []byte
+
+	// Additional fields populated by Client.QueryTracks.
+	// Timestamps are Unix timestamps in microseconds.
+	DurationMillis        int64
+	CreationTimestamp     int64
+	LastModifiedTimestamp int64
+	RecentTimestamp       int64
+	Deleted               bool
+	AvailabilityStatus    AvailabilityStatus
 }
 
 // A TrackList is one page of a track listing.
@@ -153,3 +416,363 @@ type TrackList struct {
 	// tracks.
 	PurchasedOnly bool `convert:"-"`
 }
+
+// SearchAttribute names a track attribute that a track query can be
+// restricted by.
+type SearchAttribute int
+
+const (
+	SearchTitle SearchAttribute = 1 + iota
+	SearchArtist
+	SearchAlbum
+	SearchAlbumArtist
+	SearchGenre
+	SearchAvailabilityStatus
+	SearchTrackType
+	SearchYear
+	SearchStoreId
+	SearchAlbumMetajamId
+)
+
+// Comparison is the way a Restriction compares a track attribute to
+// its value.
+type Comparison int
+
+const (
+	Equal Comparison = iota
+	NotEqual
+	GreaterThan
+	GreaterEqual
+	LessThan
+	LessEqual
+	PartialMatch
+)
+
+// A Restriction limits a track query to tracks whose attribute compares
+// to Value as given by Comparison.  Enumerated attributes, such as
+// SearchAvailabilityStatus and SearchTrackType, are compared by their
+// numeric value.
+type Restriction struct {
+	Attribute  SearchAttribute
+	Comparison Comparison
+	Value      string
+}
+
+// A RestrictionSet combines restrictions and nested restriction sets.
+// A track matches the set if it matches all of its members, or any of
+// them if Or is true.
+type RestrictionSet struct {
+	Or           bool
+	Restrictions []Restriction
+	SubSets      []*RestrictionSet
+}
+
+// SortAttribute names a track attribute that a track query can be
+// sorted by.
+type SortAttribute int
+
+const (
+	SortLastModified SortAttribute = 1
+	SortArtist       SortAttribute = 2
+	SortAlbum        SortAttribute = 3
+	SortTitle        SortAttribute = 4
+	SortTrackNumber  SortAttribute = 6
+	SortPlayCount    SortAttribute = 9
+	SortDuration     SortAttribute = 10
+	SortRating       SortAttribute = 11
+	SortCreationTime SortAttribute = 12
+)
+
+// A SortOrder sorts a track query by an attribute.
+type SortOrder struct {
+	Attribute  SortAttribute
+	Descending bool
+}
+
+// Projection selects how much of each track a track query returns.
+type Projection int
+
+const (
+	DefaultProjection Projection = iota
+	FullProjection
+	FrontendProjection
+)
+
+// A TrackQuery describes a track listing made with Client.QueryTracks.
+// The zero TrackQuery lists all undeleted tracks in the server's
+// default order.
+type TrackQuery struct {
+	// Only list tracks modified after the given Unix timestamp
+	// (microsecond precision).
+	UpdatedMin int64
+
+	// Also list deleted tracks.
+	IncludeDeleted bool
+
+	// The maximum number of tracks per page, or 0 for the server
+	// default.
+	MaxResults int
+
+	// Only list tracks matching all of the restrictions and the
+	// restriction set, if non-nil.
+	Restrictions   []Restriction
+	RestrictionSet *RestrictionSet
+
+	// The order in which to list the tracks; later sort orders
+	// break ties in earlier ones.
+	SortOrder []SortOrder
+
+	// How much of each track to return.
+	Projection Projection
+}
+
+// PlaylistType describes the origin of a playlist.
+type PlaylistType int
+
+const (
+	UserGeneratedPlaylist PlaylistType = 1 + iota
+	MagicPlaylist
+	PromoPlaylist
+)
+
+func (t PlaylistType) String() string {
+	return mmldpb.Playlist_PlaylistType(t).String()
+}
+
+// DynamicPlaylist names a playlist generated automatically by the
+// server from the user's library.
+type DynamicPlaylist int
+
+const (
+	PurchasedPlaylist DynamicPlaylist = 1 + iota
+	ThumbsUpPlaylist
+	RecentlyAddedPlaylist
+	PromotedPlaylist
+	PromotedAndPurchasedPlaylist
+)
+
+func (p DynamicPlaylist) String() string {
+	return mmlspb.GetDynamicPlaylistEntriesRequest_DynamicPlaylistEntriesType(p).String()
+}
+
+// A Playlist represents metadata about a playlist.  Timestamps are Unix
+// timestamps in microseconds.
+type Playlist struct {
+	Id                    string
+	ClientId              string
+	Name                  string
+	PlaylistType          PlaylistType
+	CreationTimestamp     int64
+	LastModifiedTimestamp int64
+	RecentTimestamp       int64
+	Deleted               bool
+}
+
+// A PlaylistEntry represents the occurrence of a track in a playlist.
+// Timestamps are Unix timestamps in microseconds.
+type PlaylistEntry struct {
+	Id                    string
+	ClientId              string
+	PlaylistId            string
+	TrackId               string
+	AbsolutePosition      int64
+	CreationTimestamp     int64
+	LastModifiedTimestamp int64
+	Deleted               bool
+
+	// The metadata of the track, if provided by the server.
+	Track *Track
+
+	// The server IDs of the entries after or before which the entry
+	// should be placed when creating or updating it.  If both are
+	// empty, a new entry is appended to its playlist and an
+	// existing one is left in place.
+	PlaceAfterEntryId  string
+	PlaceBeforeEntryId string
+}
+
+// A PlaylistList is one page of a playlist listing.
+type PlaylistList struct {
+	// The actual page of playlists.
+	Items []*Playlist `convert:"/Playlist"`
+
+	// Page token for the next page of playlists.
+	PageToken string `convert:"/ContinuationToken"`
+
+	// The last time one of the playlists in the list was modified,
+	// expressed as a Unix timestamp in microseconds.
+	UpdatedMin int64 `convert:"-"`
+}
+
+// A PlaylistEntryList is one page of a playlist entry listing.
+type PlaylistEntryList struct {
+	// The actual page of playlist entries.
+	Items []*PlaylistEntry `convert:"/PlaylistEntry"`
+
+	// Page token for the next page of playlist entries.
+	PageToken string `convert:"/ContinuationToken"`
+
+	// The last time one of the entries in the list was modified,
+	// expressed as a Unix timestamp in microseconds.
+	UpdatedMin int64 `convert:"-"`
+}
+
+// An Album summarizes the tracks of an album in the user's library.
+type Album struct {
+	Name           string
+	AlbumArtist    string
+	Artist         string
+	TrackCount     int
+	IsCompilation  bool
+	AlbumMetajamId string
+
+	// Timestamps are Unix timestamps in microseconds.
+	LastTimePlayed    int64
+	CreationTimestamp int64
+}
+
+// An Artist summarizes the tracks of an artist in the user's library.
+type Artist struct {
+	Name            string
+	TotalTrackCount int
+	Albums          []*Album `convert:"/Album"`
+}
+
+// A Genre summarizes the tracks of a genre in the user's library.
+type Genre struct {
+	Name            string
+	TotalTrackCount int
+	Albums          []*Album `convert:"/Album"`
+}
+
+// AlbumSortAttribute names an album attribute that an album listing
+// can be sorted by.
+type AlbumSortAttribute int
+
+const (
+	SortAlbumLastPlayed AlbumSortAttribute = 1 + iota
+	SortAlbumName
+	SortAlbumCreationTime
+)
+
+// Stats summarizes the contents of the user's library.
+type Stats struct {
+	// The number of tracks of each type.
+	TrackTypes map[TrackType]int
+
+	// The number of tracks in each availability status.  Tracks
+	// with StatusUploadRequested or StatusForceReupload are waiting
+	// for their audio to be uploaded, and those with
+	// StatusUploadPermanentlyFailed will never become available.
+	AvailabilityStatuses map[AvailabilityStatus]int
+
+	// Summaries of the user's playlists.
+	Playlists []*PlaylistStats
+}
+
+// PlaylistStats summarizes a playlist.
+type PlaylistStats struct {
+	PlaylistId string
+	Name       string
+	TrackCount int64
+
+	// The last time the playlist was played, expressed as a Unix
+	// timestamp in microseconds.
+	LastTimePlayed int64
+}
+
+// SeedType describes what the value of a Seed refers to.
+type SeedType int
+
+const (
+	// The value is the server ID of a track.
+	TrackSeed SeedType = iota
+
+	// The value is the name of an artist.
+	ArtistSeed
+
+	// The value is the name of an album.
+	AlbumSeed
+
+	// The value is a seed returned by the server for some other
+	// purpose and is passed as-is.
+	OpaqueSeed
+)
+
+func (t SeedType) String() string {
+	return mmldpb.MagicPlaylistSeed_SeedType(t).String()
+}
+
+// A Seed is something that a mix is generated around.
+type Seed struct {
+	Type  SeedType
+	Value string
+}
+
+// A MixRequest describes a mix to be generated with
+// Client.GenerateMix.
+type MixRequest struct {
+	// The name of the generated playlist.
+	Name string
+
+	// If non-empty, the ID of an existing playlist into which the
+	// mix is generated.
+	PlaylistId string
+
+	// The seeds of the mix.
+	Seeds []Seed
+
+	// The number of tracks to recommend, or 0 for the server
+	// default.
+	NumRecommendations int
+
+	// The name of the recommendation model to use, or the empty
+	// string for the server default.
+	ModelName string
+
+	// Whether to include the metadata of the tracks in the returned
+	// playlist entries.
+	IncludeTracks bool
+}
+
+// An UploadItem is a track to be uploaded with Client.Upload.
+type UploadItem struct {
+	// The metadata of the track.  Its ContentType must match the
+	// format of the audio data.
+	Track *Track
+
+	// The audio data of the track and its size in bytes.
+	Audio io.ReaderAt
+	Size  int64
+
+	// If Progress is non-nil, it is called with the number of bytes
+	// sent so far and the total as the upload proceeds.  Progress
+	// functions of different items can be called concurrently.
+	Progress func(sent, total int64)
+}
+
+// A LookupKey identifies an item by its server ID or, if Id is empty,
+// by its client ID.
+type LookupKey struct {
+	Id       string
+	ClientId string
+}
+
+// A Lookup describes the items to be looked up with Client.Lookup.
+type Lookup struct {
+	Tracks          []LookupKey
+	Playlists       []LookupKey
+	PlaylistEntries []LookupKey
+
+	// Whether to also find deleted items.
+	IncludeDeleted bool
+}
+
+// A LookupResult holds the items found by Client.Lookup.  Each slice
+// has an element for each key of the corresponding slice of the
+// Lookup, which is nil if the item was not found.
+type LookupResult struct {
+	Tracks          []*Track
+	Playlists       []*Playlist
+	PlaylistEntries []*PlaylistEntry
+}
@@ -0,0 +1,268 @@
+// This file implements the resumable upload of track audio to the URLs
+// returned by ImportTracks, and the Upload pipeline built on it.
+
+package musicmanager
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"sync"
+	"time"
+
+	mmssjs "github.com/lxr/go.google.musicmanager/internal/session_json"
+)
+
+// DefaultUploadConcurrency is the number of tracks Client.Upload sends
+// to the server at once if Client.UploadConcurrency is zero.
+const DefaultUploadConcurrency = 4
+
+// maxUploadAttempts is the number of consecutive attempts at uploading
+// a track that make no progress after which UploadTrack gives up.
+const maxUploadAttempts = 5
+
+// UploadTrack uploads size bytes of audio data of content type ct, read
+// from r, to a URL returned by ImportTracks, and returns the server ID
+// of the track.
+//
+// If the upload is interrupted by a network error or a server error,
+// UploadTrack asks the server how much of the data it has received and
+// resumes the upload from there.  This uses Content-Range requests in
+// the manner of Google's other resumable upload protocols; whether the
+// Music Manager upload servers honour them is not known, and if they
+// don't, an interrupted upload starts over.  UploadTrack gives up after
+// several consecutive attempts that make no progress, waiting for
+// longer after each one.
+//
+// If progress is non-nil, it is called with the number of bytes sent so
+// far and the total as the upload proceeds.  The count can go backwards
+// if the server did not receive everything that was sent before an
+// interruption.
+func (c *Client) UploadTrack(uploadURL string, r io.ReaderAt, size int64, ct ContentType, progress func(sent, total int64)) (string, error) {
+	return c.UploadTrackContext(context.Background(), uploadURL, r, size, ct, progress)
+}
+
+// UploadTrackContext is like UploadTrack but takes a context.
+func (c *Client) UploadTrackContext(ctx context.Context, uploadURL string, r io.ReaderAt, size int64, ct ContentType, progress func(sent, total int64)) (string, error) {
+	mimeType := ct.MIMEType()
+	if mimeType == "" {
+		return "", fmt.Errorf("cannot upload tracks of content type %v", ct)
+	}
+	var offset int64
+	failures := 0
+	for {
+		// Once the server has received all of the data, only its
+		// result remains to be asked for; there is nothing left
+		// to send.
+		var err error
+		if offset < size || offset == 0 {
+			var res *mmssjs.GetUploadSessionResponse
+			res, err = c.putAudio(ctx, uploadURL, r, offset, size, mimeType, progress)
+			if id, done, err := uploadResult(ctx, res, err); done {
+				return id, err
+			}
+		}
+		// The upload was interrupted, or its result is still
+		// pending, so find out where it stands.
+		res, qerr := c.queryUpload(ctx, uploadURL, size)
+		if id, done, err := uploadResult(ctx, res, qerr); done {
+			return id, err
+		}
+		n := offset
+		if qerr == nil && len(res.Transfers) > 0 {
+			n = res.Transfers[0].BytesTransferred
+		} else if qerr != nil {
+			err = qerr
+		}
+		if n > offset {
+			failures = 0
+		} else if failures++; failures >= maxUploadAttempts {
+			if err == nil {
+				err = fmt.Errorf("upload is not progressing")
+			}
+			return "", err
+		} else {
+			t := time.NewTimer(time.Duration(1<<uint(failures-1)) * time.Second)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return "", ctx.Err()
+			case <-t.C:
+			}
+		}
+		offset = n
+		if progress != nil {
+			progress(offset, size)
+		}
+	}
+}
+
+// Upload imports the tracks of the given items and uploads their audio
+// data, returning the server IDs of the tracks.  Individual tracks can
+// fail, in which case errs[i] contains the reason why uploading
+// items[i] failed; the errors are as for ImportTracks and UploadTrack.
+// Items without a Track or Audio, or with the same client ID as an
+// earlier item, fail without being imported.  Up to c.UploadConcurrency
+// tracks are uploaded at once.  As the upload URLs returned by
+// ImportTracks are short-lived, each track is imported only once its
+// upload can start.
+//
+// If c.ReportUploadState is true and the upload session has not been
+// declared started, Upload declares it started, and stopped again once
+// it has finished, even if ctx has been cancelled.  If stopping the
+// session fails, the error is returned as err; the IDs and errors of
+// the individual tracks are unaffected.
+func (c *Client) Upload(items []UploadItem) (ids []string, errs []error, err error) {
+	return c.UploadContext(context.Background(), items)
+}
+
+// UploadContext is like Upload but takes a context.
+func (c *Client) UploadContext(ctx context.Context, items []UploadItem) (ids []string, errs []error, err error) {
+	ids = make([]string, len(items))
+	errs = make([]error, len(items))
+	// Pick out the valid items.  Since the tracks are imported one
+	// at a time, ImportTracks cannot catch duplicate client IDs.
+	cids := make(map[string]bool)
+	var index []int
+	for i, item := range items {
+		switch {
+		case item.Track == nil:
+			errs[i] = fmt.Errorf("upload item has no track")
+		case item.Audio == nil:
+			errs[i] = fmt.Errorf("upload item has no audio data")
+		case cids[item.Track.ClientId]:
+			errs[i] = fmt.Errorf("trying to import two tracks with the same client-side ID")
+		default:
+			cids[item.Track.ClientId] = true
+			index = append(index, i)
+		}
+	}
+	if len(index) == 0 {
+		return ids, errs, nil
+	}
+	started := false
+	if c.ReportUploadState {
+		if started, err = c.startUpload(ctx); err != nil {
+			for _, i := range index {
+				errs[i] = err
+			}
+			return ids, errs, nil
+		}
+	}
+	n := c.UploadConcurrency
+	if n <= 0 {
+		n = DefaultUploadConcurrency
+	}
+	count := &uploadCount{total: len(index)}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < n && w < len(index); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				item := items[i]
+				urls, ierrs := c.importTracks(ctx, []*Track{item.Track}, count)
+				if errs[i] = ierrs[0]; errs[i] != nil {
+					continue
+				}
+				ids[i], errs[i] = c.UploadTrackContext(ctx, urls[0], item.Audio, item.Size, item.Track.ContentType, item.Progress)
+			}
+		}()
+	}
+	for _, i := range index {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	if started {
+		err = c.stopUpload()
+	}
+	return ids, errs, err
+}
+
+// putAudio sends the audio data in r from offset onwards to the given
+// upload URL.
+func (c *Client) putAudio(ctx context.Context, uploadURL string, r io.ReaderAt, offset, size int64, mimeType string, progress func(sent, total int64)) (*mmssjs.GetUploadSessionResponse, error) {
+	body := &progressReader{
+		r:     io.NewSectionReader(r, offset, size-offset),
+		n:     offset,
+		total: size,
+		f:     progress,
+	}
+	req, err := http.NewRequestWithContext(ctx, "PUT", uploadURL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size - offset
+	req.Header.Set("Content-Type", mimeType)
+	if offset > 0 {
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, size-1, size))
+	}
+	res := new(mmssjs.GetUploadSessionResponse)
+	return res, c.do(req, res)
+}
+
+// queryUpload asks for the status of the upload to the given URL.
+func (c *Client) queryUpload(ctx context.Context, uploadURL string, size int64) (*mmssjs.GetUploadSessionResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, "PUT", uploadURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+	res := new(mmssjs.GetUploadSessionResponse)
+	return res, c.do(req, res)
+}
+
+// uploadResult interprets the response to an upload request.  It
+// reports done if the upload has either finished, in which case it
+// returns the server ID of the track, or failed in a way that cannot
+// be recovered from by resuming it.
+func uploadResult(ctx context.Context, res *mmssjs.GetUploadSessionResponse, err error) (id string, done bool, _ error) {
+	switch {
+	case err != nil:
+		return "", !isTransient(ctx, err), err
+	case res.Error != nil:
+		return "", true, res.Error
+	case res.State == "FINALIZED" && len(res.Transfers) > 0:
+		return res.Transfers[0].Name, true, nil
+	default:
+		return "", false, nil
+	}
+}
+
+// isTransient reports whether err is a network or server error after
+// which an upload or download is worth resuming.
+func isTransient(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	switch e := err.(type) {
+	case *textproto.Error:
+		return e.Code >= 500 || e.Code == http.StatusRequestTimeout || e.Code == http.StatusTooManyRequests
+	case net.Error:
+		return true
+	default:
+		return err == io.ErrUnexpectedEOF
+	}
+}
+
+// A progressReader reports the number of bytes read through it to a
+// progress callback.
+type progressReader struct {
+	r        io.Reader
+	n, total int64
+	f        func(n, total int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if r.f != nil && n > 0 {
+		r.f(r.n, r.total)
+	}
+	return n, err
+}
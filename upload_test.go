@@ -0,0 +1,230 @@
+package musicmanager_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/lxr/go.google.musicmanager"
+	"github.com/lxr/go.google.musicmanager/musicmanagertest"
+)
+
+func TestUploadTrack(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	c := newClient(t, s)
+	audio := bytes.Repeat([]byte("0123456789"), 1000)
+	size := int64(len(audio))
+	urls, errs := c.ImportTracks([]*musicmanager.Track{
+		{ClientId: "a", Title: "a"},
+		{ClientId: "b", Title: "b", ContentType: musicmanager.FLAC},
+	})
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("ImportTracks: track %d: %v", i, err)
+		}
+	}
+
+	id, err := c.UploadTrack(urls[0], bytes.NewReader(audio), size, musicmanager.MP3, nil)
+	if err != nil {
+		t.Fatalf("UploadTrack: %v", err)
+	}
+	if _, got, _ := s.Track(id); !bytes.Equal(got, audio) {
+		t.Errorf("server has %d bytes of audio, want %d", len(got), size)
+	}
+
+	// Drop the connection every 3000 bytes, so that the upload has
+	// to be resumed three times.
+	s.UploadChunkLimit = 3000
+	var sent, total int64
+	id, err = c.UploadTrack(urls[1], bytes.NewReader(audio), size, musicmanager.FLAC, func(n, t int64) {
+		sent, total = n, t
+	})
+	if err != nil {
+		t.Fatalf("UploadTrack with dropped connections: %v", err)
+	}
+	if sent != size || total != size {
+		t.Errorf("last progress report was %d of %d, want %d of %d", sent, total, size, size)
+	}
+	if _, got, _ := s.Track(id); !bytes.Equal(got, audio) {
+		t.Errorf("server has %d bytes of resumed audio, want %d", len(got), size)
+	}
+
+	// Uploading to a finished session reports the same track.
+	again, err := c.UploadTrack(urls[1], bytes.NewReader(audio), size, musicmanager.FLAC, nil)
+	if err != nil || again != id {
+		t.Errorf("UploadTrack to a finished session: got %q, %v, want %q", again, err, id)
+	}
+	if _, err := c.UploadTrack(urls[1], bytes.NewReader(audio), size, musicmanager.M4P, nil); err == nil {
+		t.Error("UploadTrack of M4P audio succeeded")
+	}
+}
+
+func TestUploadTrackPendingResult(t *testing.T) {
+	// The server receives all of the data but drops the connection
+	// before reporting the result, which it only has ready on the
+	// second status query.
+	const size = 100
+	queries := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch cr := r.Header.Get("Content-Range"); cr {
+		case "":
+			ioutil.ReadAll(r.Body)
+			http.Error(w, "connection dropped", http.StatusServiceUnavailable)
+		case fmt.Sprintf("bytes */%d", size):
+			queries++
+			state := "OPEN"
+			if queries > 1 {
+				state = "FINALIZED"
+			}
+			fmt.Fprintf(w, `{"sessionStatus": {"state": %q, "externalFieldTransfers": [{"name": "id", "bytesTransferred": %d, "bytesTotal": %d}]}}`, state, size, size)
+		default:
+			t.Errorf("request with Content-Range %q after all data was received", cr)
+			http.Error(w, "invalid range", http.StatusBadRequest)
+		}
+	}))
+	defer s.Close()
+	c, err := musicmanager.NewClient(s.Client(), deviceID)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	id, err := c.UploadTrack(s.URL, bytes.NewReader(make([]byte, size)), size, musicmanager.MP3, nil)
+	if err != nil || id != "id" {
+		t.Errorf("UploadTrack: got %q, %v, want %q", id, err, "id")
+	}
+}
+
+func TestUpload(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	s.UploadChunkLimit = 500
+	c := newClient(t, s)
+	c.UploadConcurrency = 2
+	c.ReportUploadState = true
+	var mu sync.Mutex
+	progress := make(map[int]int64)
+	var items []musicmanager.UploadItem
+	for i := 0; i < 6; i++ {
+		i := i
+		audio := bytes.Repeat([]byte{byte(i)}, 1000+i)
+		items = append(items, musicmanager.UploadItem{
+			Track: &musicmanager.Track{ClientId: fmt.Sprint(i), Title: fmt.Sprint(i)},
+			Audio: bytes.NewReader(audio),
+			Size:  int64(len(audio)),
+			Progress: func(sent, total int64) {
+				mu.Lock()
+				progress[i] = sent
+				mu.Unlock()
+			},
+		})
+	}
+	items[3].Track.ContentType = musicmanager.M4P
+	items[4].Track = nil
+
+	ids, errs, err := c.Upload(items)
+	if err != nil {
+		t.Errorf("Upload: stopping the session: %v", err)
+	}
+	for i := range items {
+		if fail := i == 3 || i == 4; (errs[i] != nil) != fail || (ids[i] == "") != fail {
+			t.Errorf("item %d: got ID %q and error %v", i, ids[i], errs[i])
+		}
+	}
+	if _, audio, _ := s.Track(ids[5]); len(audio) != 1005 {
+		t.Errorf("server has %d bytes of item 5, want 1005", len(audio))
+	}
+	if p := progress[5]; p != 1005 {
+		t.Errorf("last progress report of item 5 was %d, want 1005", p)
+	}
+	if state := s.UploadState(); state != musicmanager.UploadStopped {
+		t.Errorf("upload state is %v, want %v", state, musicmanager.UploadStopped)
+	}
+}
+
+func TestUploadImportsOnDemand(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	c := newClient(t, s)
+	c.UploadConcurrency = 1
+	// While a track is being uploaded, the tracks after it should
+	// not have been imported yet.
+	pending := 0
+	audio := []byte("audio")
+	var items []musicmanager.UploadItem
+	for i := 0; i < 3; i++ {
+		items = append(items, musicmanager.UploadItem{
+			Track: &musicmanager.Track{ClientId: fmt.Sprint(i), Title: fmt.Sprint(i)},
+			Audio: bytes.NewReader(audio),
+			Size:  int64(len(audio)),
+			Progress: func(sent, total int64) {
+				jobs, err := c.PendingJobs()
+				if err != nil {
+					t.Errorf("PendingJobs: %v", err)
+				}
+				if len(jobs) > pending {
+					pending = len(jobs)
+				}
+			},
+		})
+	}
+	_, errs, _ := c.Upload(items)
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Upload: item %d: %v", i, err)
+		}
+	}
+	if pending != 1 {
+		t.Errorf("at most %d tracks were awaiting upload at once, want 1", pending)
+	}
+}
+
+func TestUploadCancelled(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	c := newClient(t, s)
+	c.ReportUploadState = true
+	ctx, cancel := context.WithCancel(context.Background())
+	audio := []byte("audio")
+	items := []musicmanager.UploadItem{{
+		Track: &musicmanager.Track{ClientId: "a", Title: "a"},
+		Audio: bytes.NewReader(audio),
+		Size:  int64(len(audio)),
+		// Cancel the upload once it has started.
+		Progress: func(sent, total int64) { cancel() },
+	}}
+	c.UploadContext(ctx, items)
+	if state := s.UploadState(); state != musicmanager.UploadStopped {
+		t.Errorf("upload state after cancellation is %v, want %v", state, musicmanager.UploadStopped)
+	}
+}
+
+func TestUploadInSession(t *testing.T) {
+	s := musicmanagertest.NewServer()
+	defer s.Close()
+	c := newClient(t, s)
+	c.ReportUploadState = true
+	if err := c.SetUploadState(musicmanager.UploadStarted); err != nil {
+		t.Fatalf("SetUploadState: %v", err)
+	}
+	// An upload within a session started by the caller leaves the
+	// session to the caller, even if nothing is uploaded.
+	audio := []byte("audio")
+	for _, ct := range []musicmanager.ContentType{musicmanager.MP3, musicmanager.M4P} {
+		_, errs, err := c.Upload([]musicmanager.UploadItem{{
+			Track: &musicmanager.Track{ClientId: ct.String(), Title: "a", ContentType: ct},
+			Audio: bytes.NewReader(audio),
+			Size:  int64(len(audio)),
+		}})
+		if err != nil || (errs[0] != nil) != (ct == musicmanager.M4P) {
+			t.Errorf("Upload of %v audio: got errors %v and %v", ct, errs, err)
+		}
+		if state := s.UploadState(); state != musicmanager.UploadStarted {
+			t.Errorf("upload state after uploading %v audio is %v, want %v", ct, state, musicmanager.UploadStarted)
+		}
+	}
+}